@@ -0,0 +1,186 @@
+// Package monkey embeds the Monkey interpreter in Go programs:
+//
+//	interp := monkey.New()
+//	interp.SetGlobal("limit", 10)
+//	interp.Run(`let allowed = fn(n) { n <= limit };`)
+//	result, err := interp.Call("allowed", 3)
+//
+// Go values given to the interpreter are converted with object.FromGo,
+// results can be converted back with object.ToGo
+package monkey
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"monkey/src/diagnostics"
+	"monkey/src/evaluator"
+	"monkey/src/lexer"
+	"monkey/src/object"
+	"monkey/src/parser"
+)
+
+// An Interpreter keeps its global environment from one Run to the next, so
+// later scripts see what earlier ones defined
+type Interpreter struct {
+	// Where `put` writes, os.Stdout by default
+	Stdout io.Writer
+	// Where syntax and runtime errors are reported, os.Stderr by default.
+	// Set it to io.Discard to only get them as Go errors
+	Stderr io.Writer
+
+	env *object.Environment
+	// Source of the recent scripts by name, to show the lines errors point to
+	sources *diagnostics.Sources
+	// Number of scripts given to Run so far
+	scripts int
+}
+
+// Number of scripts whose source is kept, a function defined in an older
+// one has its errors reported without the line
+const maxSources = 100
+
+func New() *Interpreter {
+	interp := &Interpreter{
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+		env:     object.NewEnvironment(),
+		sources: diagnostics.NewSources(maxSources),
+	}
+
+	// Each interpreter has its own builtins, starting with the default ones
+	rt := interp.env.Runtime()
+	rt.Builtins = evaluator.DefaultBuiltins()
+	rt.Stdout = stdout{interp}
+
+	return interp
+}
+
+// stdout writes to the current Stdout of the interpreter, which can change
+// after New
+type stdout struct {
+	interp *Interpreter
+}
+
+func (out stdout) Write(p []byte) (int, error) {
+	return out.interp.Stdout.Write(p)
+}
+
+// RegisterBuiltin makes the Go function fn available to the scripts of this
+// interpreter as the builtin name, see object.NewBuiltin for the functions
+// accepted
+func (interp *Interpreter) RegisterBuiltin(name string, fn any) error {
+	return interp.env.Runtime().Builtins.Register(name, fn)
+}
+
+// UnregisterBuiltin removes the builtin name from this interpreter, to
+// restrict what its scripts can do
+func (interp *Interpreter) UnregisterBuiltin(name string) {
+	interp.env.Runtime().Builtins.Unregister(name)
+}
+
+// Builtins lists the builtins available to the scripts of this interpreter
+func (interp *Interpreter) Builtins() []string {
+	return interp.env.Runtime().Builtins.Names()
+}
+
+// A SyntaxError reports every error found while parsing a script
+type SyntaxError struct {
+	Errors []*parser.ParseError
+}
+
+func (e *SyntaxError) Error() string {
+	messages := []string{}
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Run evaluates src and returns the value of its last statement. The error
+// is a *SyntaxError when src does not parse and the *object.Error the
+// script failed with otherwise. Errors refer to src as `<script-N>`, the
+// Nth script given to Run
+func (interp *Interpreter) Run(src string) (object.Object, error) {
+	interp.scripts++
+	return interp.run(fmt.Sprintf("<script-%d>", interp.scripts), src)
+}
+
+// RunFile is Run for the script stored at path, errors refer to path
+func (interp *Interpreter) RunFile(path string) (object.Object, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return interp.run(path, string(src))
+}
+
+func (interp *Interpreter) run(filename, src string) (object.Object, error) {
+	p := parser.New(lexer.NewFile(filename, src))
+	program := p.ParseProgram()
+
+	if len(p.ParseErrors()) != 0 {
+		for _, err := range p.ParseErrors() {
+			diagnostics.Render(interp.Stderr, src, diagnostics.FromParseError(err), diagnostics.Options{})
+		}
+		return nil, &SyntaxError{Errors: p.ParseErrors()}
+	}
+
+	interp.sources.Add(filename, src)
+	return interp.result(evaluator.SafeEval(program, interp.env))
+}
+
+// SetGlobal binds name in the global environment to value, replacing what a
+// script or an earlier call may have bound to it
+func (interp *Interpreter) SetGlobal(name string, value any) error {
+	var obj object.Object
+	var err error
+	if reflect.ValueOf(value).Kind() == reflect.Func {
+		// Named after the global in error messages
+		obj, err = object.NewBuiltin(name, value)
+	} else {
+		obj, err = object.FromGo(value)
+	}
+	if err != nil {
+		return fmt.Errorf("global %s: %w", name, err)
+	}
+	interp.env.Set(name, obj)
+	return nil
+}
+
+// GetGlobal returns the value bound to name by a script or by SetGlobal
+func (interp *Interpreter) GetGlobal(name string) (object.Object, bool) {
+	return interp.env.Get(name)
+}
+
+// Call calls the global function fnName with args
+func (interp *Interpreter) Call(fnName string, args ...any) (object.Object, error) {
+	fn, ok := interp.env.Get(fnName)
+	if !ok {
+		return nil, fmt.Errorf("function not found: `%s`", fnName)
+	}
+
+	objects := make([]object.Object, len(args))
+	for i, arg := range args {
+		obj, err := object.FromGo(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i+1, err)
+		}
+		objects[i] = obj
+	}
+
+	return interp.result(evaluator.Apply(fn, objects, interp.env))
+}
+
+// result turns an error object into a Go error, reporting it on Stderr
+func (interp *Interpreter) result(evaluated object.Object) (object.Object, error) {
+	if err, ok := evaluated.(*object.Error); ok {
+		src := interp.sources.Get(err.Pos.Filename)
+		diagnostics.Render(interp.Stderr, src, diagnostics.FromError(err), diagnostics.Options{})
+		return nil, err
+	}
+	return evaluated, nil
+}
@@ -0,0 +1,246 @@
+package monkey
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"monkey/src/object"
+)
+
+func newTestInterpreter() (*Interpreter, *bytes.Buffer, *bytes.Buffer) {
+	var stdout, stderr bytes.Buffer
+	interp := New()
+	interp.Stdout = &stdout
+	interp.Stderr = &stderr
+	return interp, &stdout, &stderr
+}
+
+func TestRun(t *testing.T) {
+	interp, stdout, _ := newTestInterpreter()
+
+	if _, err := interp.Run(`let double = fn(x) { x * 2 }; put("hello", 1);`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, err := interp.Run("double(21)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.Inspect() != "42" {
+		t.Errorf("wrong result. Expected: 42, got: %s", result.Inspect())
+	}
+	if stdout.String() != "hello\n1\n" {
+		t.Errorf("wrong output. Expected: %q, got: %q", "hello\n1\n", stdout.String())
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	interp, _, stderr := newTestInterpreter()
+
+	_, err := interp.Run("let x 1;")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || len(syntaxErr.Errors) != 1 {
+		t.Fatalf("expected a syntax error, got: %v", err)
+	}
+	if err.Error() != "<script-1>:1:7: Expect token to be =, got INT instead" {
+		t.Errorf("wrong error message, got: %s", err.Error())
+	}
+
+	interp.Run("let add = fn(a, b) {\n  a + b\n};")
+	stderr.Reset()
+	_, err = interp.Run(`add(1, true)`)
+	var runtimeErr *object.Error
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected a runtime error, got: %v", err)
+	}
+	if runtimeErr.Message != "type missmatch: INTEGER + BOOLEAN" {
+		t.Errorf("wrong error message, got: %s", runtimeErr.Message)
+	}
+
+	// The error is shown in the script that defined add
+	if !strings.Contains(stderr.String(), "2 |   a + b") {
+		t.Errorf("error report does not show the failing line:\n%s", stderr.String())
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.mk")
+	if err := os.WriteFile(path, []byte("let limit = 10;\nlimit + true"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	interp, _, stderr := newTestInterpreter()
+	_, err := interp.RunFile(path)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !strings.HasPrefix(stderr.String(), path+":2:1: error: type missmatch") {
+		t.Errorf("wrong error report:\n%s", stderr.String())
+	}
+
+	limit, ok := interp.GetGlobal("limit")
+	if !ok || limit.Inspect() != "10" {
+		t.Errorf("limit not defined by the file, got: %v", limit)
+	}
+
+	if _, err := interp.RunFile(filepath.Join(t.TempDir(), "missing.mk")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a not exist error, got: %v", err)
+	}
+
+	// Files are not counted as scripts
+	if _, err := interp.Run("let x 1;"); err == nil || !strings.HasPrefix(err.Error(), "<script-1>:") {
+		t.Errorf("wrong script name, got: %v", err)
+	}
+}
+
+func TestSourcesAreForgotten(t *testing.T) {
+	interp, _, stderr := newTestInterpreter()
+
+	interp.Run("let add = fn(a, b) {\n  a + b\n};")
+	for i := 0; i < maxSources; i++ {
+		interp.Run("1")
+	}
+	stderr.Reset()
+
+	// The error is still reported, without the line of the forgotten script
+	if _, err := interp.Run("add(1, true)"); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !strings.HasPrefix(stderr.String(), "<script-1>:2:3: error: type missmatch") || strings.Contains(stderr.String(), "a + b") {
+		t.Errorf("wrong error report:\n%s", stderr.String())
+	}
+}
+
+func TestGlobalsAndCall(t *testing.T) {
+	interp, _, _ := newTestInterpreter()
+	interp.Stderr = io.Discard
+
+	interp.SetGlobal("limit", 10)
+	interp.SetGlobal("double", func(n int64) int64 { return n * 2 })
+	interp.Run("let allowed = fn(n) { double(n) <= limit };")
+
+	tests := []struct {
+		fnName   string
+		args     []any
+		expected string
+		err      string
+	}{
+		{"allowed", []any{3}, "true", ""},
+		{"allowed", []any{&object.Integer{Value: 6}}, "false", ""},
+		{"double", []any{uint8(4)}, "8", ""},
+		{"allowed", []any{}, "", "function allowed expects 1 argument, got 0"},
+		{"allowed", []any{"3"}, "", "argument 1 to `double` must be INTEGER, got STRING"},
+		{"allowed", []any{make(chan int)}, "", "argument 1: unsupported Go type: chan int"},
+		{"limit", []any{}, "", "not a function: INTEGER"},
+		{"missing", []any{}, "", "function not found: `missing`"},
+	}
+
+	for _, tt := range tests {
+		result, err := interp.Call(tt.fnName, tt.args...)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Call(%s) wrong error. Expected: %s, got: %v", tt.fnName, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Call(%s) unexpected error: %v", tt.fnName, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("Call(%s) wrong result. Expected: %s, got: %s", tt.fnName, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestInterpretersAreIndependent(t *testing.T) {
+	first, firstOut, _ := newTestInterpreter()
+	second, secondOut, _ := newTestInterpreter()
+
+	first.Run(`let x = 1; put("first")`)
+	second.Run(`put("second")`)
+
+	if _, ok := second.GetGlobal("x"); ok {
+		t.Errorf("x leaked into the second interpreter")
+	}
+	if firstOut.String() != "first\n" || secondOut.String() != "second\n" {
+		t.Errorf("outputs mixed up: %q, %q", firstOut.String(), secondOut.String())
+	}
+}
+
+func TestGoValues(t *testing.T) {
+	type rule struct {
+		Name  string `monkey:"name"`
+		Limit int    `monkey:"limit"`
+	}
+
+	interp, _, _ := newTestInterpreter()
+	if err := interp.SetGlobal("rules", []rule{{"a", 1}, {"b", 2}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := interp.SetGlobal("bad", make(chan int)); err == nil || err.Error() != "global bad: unsupported Go type: chan int" {
+		t.Errorf("wrong error, got: %v", err)
+	}
+
+	result, err := interp.Run(`{"name": rules[1]["name"] + "!", "limit": rules[0]["limit"] + rules[1]["limit"]}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got rule
+	if err := object.ToGo(result, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != (rule{"b!", 3}) {
+		t.Errorf("wrong result, got: %+v", got)
+	}
+}
+
+func TestBuiltinRegistries(t *testing.T) {
+	tenant, _, _ := newTestInterpreter()
+	tenant.Stderr = io.Discard
+	admin, adminOut, _ := newTestInterpreter()
+
+	tenant.UnregisterBuiltin("put")
+	if err := admin.RegisterBuiltin("shutdown", func() string { return "bye" }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := admin.RegisterBuiltin("len", func(any) int64 { return -1 }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := tenant.Run(`put(1)`); err == nil || err.Error() != "identifier not found: `put`" {
+		t.Errorf("put not removed from the tenant, got: %v", err)
+	}
+	if _, err := tenant.Run(`shutdown()`); err == nil {
+		t.Errorf("shutdown leaked into the tenant")
+	}
+	if result, _ := tenant.Run(`len("abc")`); result.Inspect() != "3" {
+		t.Errorf("len overridden in the tenant, got: %s", result.Inspect())
+	}
+
+	if result, _ := admin.Run(`put(shutdown()); len("abc")`); result.Inspect() != "-1" {
+		t.Errorf("len not overridden in the admin shell, got: %s", result.Inspect())
+	}
+	if adminOut.String() != "bye\n" {
+		t.Errorf("wrong output, got: %q", adminOut.String())
+	}
+
+	names := strings.Join(admin.Builtins(), " ")
+	if !strings.Contains(names, "len") || !strings.Contains(names, "put") || !strings.Contains(names, "shutdown") {
+		t.Errorf("wrong builtins listed, got: %s", names)
+	}
+	if strings.Contains(strings.Join(tenant.Builtins(), " "), "put") {
+		t.Errorf("put listed in the tenant")
+	}
+
+	// The defaults of the evaluator are untouched
+	if _, err := New().Run(`shutdown()`); err == nil {
+		t.Errorf("shutdown leaked into the default builtins")
+	}
+}
@@ -2,7 +2,10 @@ package ast
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"monkey/src/token"
 )
@@ -11,6 +14,10 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	// Pos is the position of the first character of the node
+	Pos() token.Position
+	// End is the position right after the last character of the node
+	End() token.Position
 }
 
 type Statement interface {
@@ -23,10 +30,21 @@ type LetStatement struct {
 	Token token.Token // The LET token
 	Name  *Identifier
 	Value Expression
+	Doc   *CommentGroup // The /// comments right above the statement, or nil
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	if ls.Name != nil {
+		return ls.Name.End()
+	}
+	return ls.Token.End
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
@@ -47,6 +65,13 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position {
+	if rs.ReturnValue != nil {
+		return rs.ReturnValue.End()
+	}
+	return rs.Token.End
+}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(rs.TokenLiteral() + " ")
@@ -68,6 +93,8 @@ type Identifier struct {
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
 func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 
 type Expression interface {
 	Node
@@ -81,6 +108,18 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position {
+	if es.Expression != nil {
+		return es.Expression.Pos()
+	}
+	return es.Token.Pos
+}
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -97,6 +136,19 @@ type IntegerLiteral struct {
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 func (il *IntegerLiteral) expressionNode()      {}
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
 
 type PrefixExpression struct {
 	Token    token.Token
@@ -106,6 +158,13 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End
+}
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -125,6 +184,18 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -144,6 +215,8 @@ type Boolean struct {
 func (bl *Boolean) expressionNode()      {}
 func (bl *Boolean) TokenLiteral() string { return bl.Token.Literal }
 func (bl *Boolean) String() string       { return bl.Token.Literal }
+func (bl *Boolean) Pos() token.Position  { return bl.Token.Pos }
+func (bl *Boolean) End() token.Position  { return bl.Token.End }
 
 type IfExpression struct {
 	Token       token.Token
@@ -154,6 +227,19 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	if ie.Condition != nil {
+		return ie.Condition.End()
+	}
+	return ie.Token.End
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -170,12 +256,23 @@ func (ie *IfExpression) String() string {
 }
 
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // The { token
 	Statements []Statement
+	Rbrace     token.Position // Position of the closing }
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position {
+	if bs.Rbrace.IsValid() {
+		return bs.Rbrace.Advance(1)
+	}
+	if n := len(bs.Statements); n > 0 {
+		return bs.Statements[n-1].End()
+	}
+	return bs.Token.End
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 
@@ -188,12 +285,20 @@ func (bs *BlockStatement) String() string {
 
 type FunctionLiteral struct {
 	Token      token.Token
+	Name       string // Set for `let name = fn...`, empty for anonymous functions
 	Parameters []*Identifier
 	Body       *BlockStatement
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FunctionLiteral) End() token.Position {
+	if fl.Body != nil {
+		return fl.Body.End()
+	}
+	return fl.Token.End
+}
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
@@ -212,13 +317,26 @@ func (fl *FunctionLiteral) String() string {
 }
 
 type CallExpression struct {
-	Token     token.Token
+	Token     token.Token // The ( token
 	Function  Expression
 	Arguments []Expression
+	Rparen    token.Position // Position of the closing )
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
+func (ce *CallExpression) End() token.Position {
+	if ce.Rparen.IsValid() {
+		return ce.Rparen.Advance(1)
+	}
+	return ce.Token.End
+}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -242,15 +360,59 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
-func (sl *StringLiteral) String() string       { return sl.Token.Literal }
+func (sl *StringLiteral) String() string       { return quote(sl.Value) }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
+
+// quote returns s as a "..." literal that reads back as s, escaping quotes,
+// backslashes and characters that are not printable
+func quote(s string) string {
+	var out bytes.Buffer
+	out.WriteByte('"')
+
+	for i := 0; i < len(s); {
+		ch, width := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case ch == utf8.RuneError && width == 1:
+			// Invalid UTF-8 is kept as is, like the lexer does
+			out.WriteByte(s[i])
+		case ch == '"':
+			out.WriteString(`\"`)
+		case ch == '\\':
+			out.WriteString(`\\`)
+		case ch == '\n':
+			out.WriteString(`\n`)
+		case ch == '\t':
+			out.WriteString(`\t`)
+		case ch == '\r':
+			out.WriteString(`\r`)
+		case !unicode.IsPrint(ch):
+			fmt.Fprintf(&out, "\\u{%x}", ch)
+		default:
+			out.WriteRune(ch)
+		}
+		i += width
+	}
+
+	out.WriteByte('"')
+	return out.String()
+}
 
 type ArrayLiteral struct {
-	Token    token.Token
+	Token    token.Token // The [ token
 	Elements []Expression
+	Rbracket token.Position // Position of the closing ]
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position {
+	if al.Rbracket.IsValid() {
+		return al.Rbracket.Advance(1)
+	}
+	return al.Token.End
+}
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elements := []string{}
@@ -267,13 +429,29 @@ func (al *ArrayLiteral) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token // The [ token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Position // Position of the closing ]
 }
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *IndexExpression) End() token.Position {
+	if ie.Rbracket.IsValid() {
+		return ie.Rbracket.Advance(1)
+	}
+	if ie.Index != nil {
+		return ie.Index.End()
+	}
+	return ie.Token.End
+}
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -286,12 +464,20 @@ func (ie *IndexExpression) String() string {
 }
 
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token // The { token
+	Pairs  map[Expression]Expression
+	Rbrace token.Position // Position of the closing }
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) End() token.Position {
+	if hl.Rbrace.IsValid() {
+		return hl.Rbrace.Advance(1)
+	}
+	return hl.Token.End
+}
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
@@ -308,6 +494,81 @@ func (hl *HashLiteral) String() string {
 	return out.String()
 }
 
+// Placeholder for an expression that could not be parsed
+type BadExpression struct {
+	Token token.Token    // The first token of the malformed expression
+	To    token.Position // Position right after the malformed expression
+}
+
+func (be *BadExpression) expressionNode()      {}
+func (be *BadExpression) TokenLiteral() string { return be.Token.Literal }
+func (be *BadExpression) String() string       { return "<bad expression>" }
+func (be *BadExpression) Pos() token.Position  { return be.Token.Pos }
+func (be *BadExpression) End() token.Position {
+	if be.To.IsValid() {
+		return be.To
+	}
+	return be.Token.End
+}
+
+// Placeholder for a statement that could not be parsed
+type BadStatement struct {
+	Token token.Token    // The first token of the malformed statement
+	To    token.Position // Position right after the malformed statement
+}
+
+func (bs *BadStatement) statementNode()       {}
+func (bs *BadStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BadStatement) String() string       { return "<bad statement>" }
+func (bs *BadStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BadStatement) End() token.Position {
+	if bs.To.IsValid() {
+		return bs.To
+	}
+	return bs.Token.End
+}
+
+// A single comment, the token literal holds the whole text including the
+// comment markers
+type Comment struct {
+	Token token.Token // The COMMENT token
+}
+
+func (c *Comment) Pos() token.Position { return c.Token.Pos }
+func (c *Comment) End() token.Position { return c.Token.End }
+
+// A sequence of comments with nothing in between
+type CommentGroup struct {
+	List []*Comment
+}
+
+func (g *CommentGroup) Pos() token.Position { return g.List[0].Pos() }
+func (g *CommentGroup) End() token.Position { return g.List[len(g.List)-1].End() }
+
+// Text returns the text of the comments without the comment markers, one
+// line per comment. For `/// text` both the slashes and the first space are
+// removed
+func (g *CommentGroup) Text() string {
+	if g == nil {
+		return ""
+	}
+
+	lines := []string{}
+	for _, c := range g.List {
+		text := c.Token.Literal
+		switch {
+		case strings.HasPrefix(text, "//"):
+			text = strings.TrimLeft(text, "/")
+		case strings.HasPrefix(text, "/*"):
+			text = strings.TrimSuffix(strings.TrimPrefix(text, "/*"), "*/")
+		}
+		text = strings.TrimPrefix(text, " ")
+		lines = append(lines, strings.TrimRight(text, " \t\r"))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
 type Program struct {
 	Statements []Statement
 }
@@ -320,6 +581,20 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) == 0 {
+		return token.Position{}
+	}
+	return p.Statements[0].Pos()
+}
+
+func (p *Program) End() token.Position {
+	if len(p.Statements) == 0 {
+		return token.Position{}
+	}
+	return p.Statements[len(p.Statements)-1].End()
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -25,3 +25,27 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() return wrong value, got :%s", program.String())
 	}
 }
+
+func TestStringLiteralString(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"hello", `"hello"`},
+		{"say \"hi\"", `"say \"hi\""`},
+		{"back\\slash", `"back\\slash"`},
+		{"line\nbreak\ttab\rreturn", `"line\nbreak\ttab\rreturn"`},
+		{"bell\a", `"bell\u{7}"`},
+		{"日本語", `"日本語"`},
+	}
+
+	for _, tt := range tests {
+		sl := &StringLiteral{
+			Token: token.Token{Type: token.STRING, Literal: tt.value},
+			Value: tt.value,
+		}
+		if sl.String() != tt.expected {
+			t.Errorf("sl.String() wrong. Expected: %s, got: %s", tt.expected, sl.String())
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package diagnostics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"monkey/src/object"
+	"monkey/src/parser"
+	"monkey/src/token"
+)
+
+/*
+  A diagnostic is rendered like a compiler report:
+
+    main.mk:2:7: error: Expect token to be =, got INT instead
+      |
+    2 | let y 2;
+      |       ^
+      = note: expected =
+*/
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+// A message about a span of source code
+type Diagnostic struct {
+	Severity Severity
+	Pos      token.Position
+	End      token.Position // Right after the span, may be invalid for a single character
+	Message  string
+	Notes    []string // Extra context, rendered as `= note: ...`
+	Hints    []string // Suggested fixes, rendered as `= help: ...`
+}
+
+// FromParseError turns a syntax error into a diagnostic
+func FromParseError(err *parser.ParseError) *Diagnostic {
+	d := &Diagnostic{
+		Severity: Error,
+		Pos:      err.Pos,
+		End:      err.Actual.End,
+		Message:  err.Message,
+	}
+
+	switch err.Kind {
+	case parser.UnexpectedToken:
+		if len(err.Expected) > 0 {
+			expected := []string{}
+			for _, t := range err.Expected {
+				expected = append(expected, string(t))
+			}
+			d.Notes = append(d.Notes, "expected "+strings.Join(expected, " or "))
+		}
+	case parser.NoPrefixParseFn:
+		d.Notes = append(d.Notes, fmt.Sprintf("an expression can not start with %s", err.Actual.Type))
+	}
+
+	return d
+}
+
+// FromError turns a runtime error into a diagnostic
+func FromError(err *object.Error) *Diagnostic {
+	d := &Diagnostic{
+		Severity: Error,
+		Pos:      err.Pos,
+		End:      err.End,
+		Message:  err.Message,
+	}
+
+	// The call stack, innermost call first
+	calls := []string{}
+	for i := len(err.Trace) - 1; i >= 0; i-- {
+		frame := err.Trace[i]
+		calls = append(calls, fmt.Sprintf("in %s, called at %s", frame.Name(), frame.Pos))
+	}
+	d.Notes = append(d.Notes, object.CollapseRepeated(calls, func(n int) string {
+		return fmt.Sprintf("previous frame repeated %d more times", n)
+	})...)
+
+	if err.Internal {
+		d.Notes = append(d.Notes, "this is a bug in the interpreter, not in the program")
+	}
+
+	return d
+}
+
+type Options struct {
+	// Color the output with ANSI escape codes
+	Color bool
+}
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiBlue   = "\x1b[34m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// Render writes the diagnostic to w, showing the offending line of src with
+// the span underlined. src is the whole text of the file the diagnostic
+// points into
+func Render(w io.Writer, src string, d *Diagnostic, opts Options) {
+	var out bytes.Buffer
+	paint := func(color, s string) string {
+		if !opts.Color {
+			return s
+		}
+		return color + s + ansiReset
+	}
+
+	severityColor := ansiRed
+	switch d.Severity {
+	case Warning:
+		severityColor = ansiYellow
+	case Note:
+		severityColor = ansiCyan
+	}
+
+	if d.Pos.IsValid() {
+		out.WriteString(paint(ansiBold, d.Pos.String()+":"))
+		out.WriteString(" ")
+	}
+	out.WriteString(paint(ansiBold+severityColor, d.Severity.String()+":"))
+	out.WriteString(" ")
+	out.WriteString(paint(ansiBold, d.Message))
+	out.WriteString("\n")
+
+	line, ok := sourceLine(src, d.Pos.Line)
+	gutter := strings.Repeat(" ", len(fmt.Sprint(d.Pos.Line)))
+
+	if ok && d.Pos.Column > 0 && d.Pos.Column <= len(line)+1 {
+		start := d.Pos.Column - 1
+		end := len(line)
+		if d.End.IsValid() && d.End.Line == d.Pos.Line && d.End.Column-1 <= len(line) {
+			end = d.End.Column - 1
+		}
+		width := utf8.RuneCountInString(line[start:max(start, end)])
+
+		out.WriteString(paint(ansiBlue, gutter+" |") + "\n")
+		out.WriteString(paint(ansiBlue, fmt.Sprintf("%d |", d.Pos.Line)) + " " + line + "\n")
+		out.WriteString(paint(ansiBlue, gutter+" |") + " " + padding(line[:start]))
+		out.WriteString(paint(ansiBold+severityColor, underline(width)) + "\n")
+	}
+
+	for _, note := range d.Notes {
+		out.WriteString(paint(ansiBlue, gutter+" =") + " " + paint(ansiBold, "note:") + " " + note + "\n")
+	}
+	for _, hint := range d.Hints {
+		out.WriteString(paint(ansiBlue, gutter+" =") + " " + paint(ansiBold+ansiGreen, "help:") + " " + hint + "\n")
+	}
+
+	w.Write(out.Bytes())
+}
+
+// sourceLine returns the 1-based line n of src without its line ending
+func sourceLine(src string, n int) (string, bool) {
+	if n < 1 {
+		return "", false
+	}
+	lines := strings.Split(src, "\n")
+	if n > len(lines) {
+		return "", false
+	}
+	return strings.TrimSuffix(lines[n-1], "\r"), true
+}
+
+// padding returns blanks as wide as prefix, keeping tabs so the underline
+// lines up with the source line whatever the tab width is
+func padding(prefix string) string {
+	var out strings.Builder
+	for _, ch := range prefix {
+		if ch == '\t' {
+			out.WriteRune('\t')
+		} else {
+			out.WriteRune(' ')
+		}
+	}
+	return out.String()
+}
+
+// underline returns `^~~~` spanning width characters, at least a single `^`
+func underline(width int) string {
+	if width < 1 {
+		width = 1
+	}
+	return "^" + strings.Repeat("~", width-1)
+}
@@ -0,0 +1,189 @@
+package diagnostics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"monkey/src/evaluator"
+	"monkey/src/lexer"
+	"monkey/src/object"
+	"monkey/src/parser"
+	"monkey/src/token"
+)
+
+func TestRenderParseError(t *testing.T) {
+	input := "let x = 1;\nlet y 2;"
+
+	p := parser.New(lexer.NewFile("main.mk", input))
+	p.ParseProgram()
+
+	if len(p.ParseErrors()) != 1 {
+		t.Fatalf("expected 1 parse error, got %d", len(p.ParseErrors()))
+	}
+
+	var out bytes.Buffer
+	Render(&out, input, FromParseError(p.ParseErrors()[0]), Options{})
+
+	expected := `main.mk:2:7: error: Expect token to be =, got INT instead
+  |
+2 | let y 2;
+  |       ^
+  = note: expected =
+`
+	if out.String() != expected {
+		t.Errorf("wrong rendering. Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRenderRuntimeError(t *testing.T) {
+	input := "let add = fn(a, b) {\n\ta + b\n};\nadd(1, \"two\")"
+
+	p := parser.New(lexer.NewFile("main.mk", input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("unexpected parse errors: %q", p.Errors())
+	}
+
+	evaluated := evaluator.Eval(program, object.NewEnvironment())
+	err, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+	}
+
+	var out bytes.Buffer
+	Render(&out, input, FromError(err), Options{})
+
+	expected := "main.mk:2:2: error: type missmatch: INTEGER + STRING\n" +
+		"  |\n" +
+		"2 | \ta + b\n" +
+		"  | \t^~~~~\n" +
+		"  = note: in add, called at main.mk:4:1\n"
+	if out.String() != expected {
+		t.Errorf("wrong rendering. Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRenderRecursionDepthError(t *testing.T) {
+	input := "let f = fn(n) { 1 + f(n + 1) };\nf(0)"
+
+	env := object.NewEnvironment()
+	env.Runtime().MaxCallDepth = 100
+	evaluated := evaluator.Eval(parser.New(lexer.NewFile("main.mk", input)).ParseProgram(), env)
+	err, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+	}
+
+	var out bytes.Buffer
+	Render(&out, input, FromError(err), Options{})
+
+	expected := "main.mk:1:21: error: maximum recursion depth exceeded: 100 calls deep in function f\n" +
+		"  |\n" +
+		"1 | let f = fn(n) { 1 + f(n + 1) };\n" +
+		"  |                     ^~~~~~~~\n" +
+		"  = note: in f, called at main.mk:1:21\n" +
+		"  = note: in f, called at main.mk:1:21\n" +
+		"  = note: in f, called at main.mk:1:21\n" +
+		"  = note: previous frame repeated 96 more times\n" +
+		"  = note: in f, called at main.mk:2:1\n"
+	if out.String() != expected {
+		t.Errorf("wrong rendering. Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRenderNotesAndHints(t *testing.T) {
+	d := &Diagnostic{
+		Severity: Warning,
+		Pos:      token.Position{Line: 1, Column: 5, Offset: 4},
+		End:      token.Position{Line: 1, Column: 9, Offset: 8},
+		Message:  "unused variable",
+		Notes:    []string{"declared here"},
+		Hints:    []string{"remove the let statement"},
+	}
+
+	var out bytes.Buffer
+	Render(&out, "let café = 1;", d, Options{})
+
+	expected := `1:5: warning: unused variable
+  |
+1 | let café = 1;
+  |     ^~~~
+  = note: declared here
+  = help: remove the let statement
+`
+	if out.String() != expected {
+		t.Errorf("wrong rendering. Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRenderColor(t *testing.T) {
+	d := &Diagnostic{
+		Pos:     token.Position{Line: 1, Column: 1},
+		Message: "boom",
+	}
+
+	var plain, colored bytes.Buffer
+	Render(&plain, "x", d, Options{})
+	Render(&colored, "x", d, Options{Color: true})
+
+	if strings.Contains(plain.String(), "\x1b[") {
+		t.Errorf("plain output contains escape codes: %q", plain.String())
+	}
+	if !strings.Contains(colored.String(), ansiRed) {
+		t.Errorf("colored output does not contain red: %q", colored.String())
+	}
+}
+
+func TestRenderWithoutSource(t *testing.T) {
+	d := &Diagnostic{
+		Pos:     token.Position{Filename: "lib.mk", Line: 40, Column: 3},
+		Message: "identifier not found: `x`",
+	}
+
+	var out bytes.Buffer
+	Render(&out, "", d, Options{})
+
+	expected := "lib.mk:40:3: error: identifier not found: `x`\n"
+	if out.String() != expected {
+		t.Errorf("wrong rendering. Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRenderInternalError(t *testing.T) {
+	err := &object.Error{Message: "internal error: boom", Internal: true}
+
+	var out bytes.Buffer
+	Render(&out, "", FromError(err), Options{})
+
+	expected := "error: internal error: boom\n" +
+		"  = note: this is a bug in the interpreter, not in the program\n"
+	if out.String() != expected {
+		t.Errorf("wrong rendering. Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestSources(t *testing.T) {
+	sources := NewSources(2)
+	sources.Add("a.mk", "a")
+	sources.Add("b.mk", "b")
+	sources.Add("a.mk", "a2")
+	sources.Add("c.mk", "c")
+
+	tests := []struct {
+		filename string
+		expected string
+	}{
+		// Added again after b.mk, so b.mk is the oldest one
+		{"a.mk", "a2"},
+		{"b.mk", ""},
+		{"c.mk", "c"},
+		{"missing.mk", ""},
+	}
+
+	for _, tt := range tests {
+		if got := sources.Get(tt.filename); got != tt.expected {
+			t.Errorf("wrong source for %s. Expected: %q, got: %q", tt.filename, tt.expected, got)
+		}
+	}
+}
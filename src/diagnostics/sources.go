@@ -0,0 +1,45 @@
+package diagnostics
+
+// Sources keeps the text of the most recent files of a session by name, so
+// that a runtime error can show the line it points to even when it comes
+// from a function defined in an earlier file. Past the limit the oldest file
+// is forgotten, its errors are then rendered without the source line
+type Sources struct {
+	limit int
+	names []string // Oldest first
+	texts map[string]string
+}
+
+func NewSources(limit int) *Sources {
+	return &Sources{limit: limit, texts: map[string]string{}}
+}
+
+// Add keeps src as the text of filename, replacing the previous text of that
+// name and forgetting the oldest file when over the limit
+func (s *Sources) Add(filename, src string) {
+	if _, ok := s.texts[filename]; ok {
+		s.remove(filename)
+	}
+	s.names = append(s.names, filename)
+	s.texts[filename] = src
+
+	if len(s.names) > s.limit {
+		delete(s.texts, s.names[0])
+		s.names = s.names[1:]
+	}
+}
+
+// Get returns the text of filename, empty when it is unknown or forgotten
+func (s *Sources) Get(filename string) string {
+	return s.texts[filename]
+}
+
+func (s *Sources) remove(filename string) {
+	for i, name := range s.names {
+		if name == filename {
+			s.names = append(s.names[:i], s.names[i+1:]...)
+			break
+		}
+	}
+	delete(s.texts, filename)
+}
@@ -2,109 +2,143 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
 	"monkey/src/object"
 )
 
-var builtins = map[string]*object.Builtin{
-	"len": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. Got: %d, take: 1", len(args))
-			}
+/*
+  Builtins are ordinary Go functions, object.NewBuiltin checks the number
+  and types of their arguments and converts them. A parameter of type
+  object.Object accepts anything, the function checks it itself and reports
+  a wrong type with object.ArgumentError so that every builtin fails the
+  same way:
 
-			switch arg := args[0].(type) {
-			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
-			case *object.Array:
-				return &object.Integer{Value: int64(len(arg.Elements))}
-			default:
-				return newError("argument to `len` not supported: %s", arg.Type())
-			}
-		},
-	},
+    builtin len expects 1 argument, got 2
+    argument 1 to `first` must be ARRAY, got INTEGER
 
-	"first": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. Got: %d, take: 1", len(args))
-			}
+  A first parameter of type object.CallContext is not an argument, it gives
+  the builtin the output of the program and a way to call the functions it
+  is given.
+*/
 
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("arguments must be type of ARRAY, got: %s", args[0].Type())
-			}
+// The builtins of environments without their own registry
+var builtins = object.NewRegistry()
 
-			arr := args[0].(*object.Array)
-			if len(arr.Elements) > 0 {
-				return arr.Elements[0]
-			}
+func init() {
+	mustRegister("len", func(arg object.Object) (int64, error) {
+		switch arg := arg.(type) {
+		case *object.String:
+			return int64(len(arg.Value)), nil
+		case *object.Array:
+			return int64(len(arg.Elements)), nil
+		default:
+			return 0, object.ArgumentError("len", 1, "STRING or ARRAY", arg)
+		}
+	})
 
-			return NULL
-		},
-	},
-	"last": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. Got: %d, take: 1", len(args))
-			}
+	mustRegister("first", func(arr *object.Array) object.Object {
+		if len(arr.Elements) > 0 {
+			return arr.Elements[0]
+		}
+		return NULL
+	})
 
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("arguments must be type of ARRAY, got: %s", args[0].Type())
-			}
+	mustRegister("last", func(arr *object.Array) object.Object {
+		length := len(arr.Elements)
+		if length > 0 {
+			return arr.Elements[length-1]
+		}
+		return NULL
+	})
 
-			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
-			if length > 0 {
-				return arr.Elements[length-1]
-			}
+	mustRegister("rest", func(arr *object.Array) object.Object {
+		length := len(arr.Elements)
+		if length > 0 {
+			newElements := make([]object.Object, length-1)
+			copy(newElements, arr.Elements[1:length])
+			return &object.Array{Elements: newElements}
+		}
+		return NULL
+	})
 
-			return NULL
-		},
-	},
-	"rest": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `rest` must be ARRAY, got %s",
-					args[0].Type())
-			}
-			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
-			if length > 0 {
-				newElements := make([]object.Object, length-1)
-				copy(newElements, arr.Elements[1:length])
-				return &object.Array{Elements: newElements}
-			}
-			return NULL
-		},
-	},
-	"push": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. got=%d, want=2",
-					len(args))
+	mustRegister("push", func(arr *object.Array, element object.Object) *object.Array {
+		length := len(arr.Elements)
+		newElements := make([]object.Object, length+1)
+		copy(newElements, arr.Elements)
+		newElements[length] = element
+		return &object.Array{Elements: newElements}
+	})
+
+	mustRegister("float", func(arg object.Object) (object.Object, error) {
+		switch arg := arg.(type) {
+		case *object.Float:
+			return arg, nil
+		case *object.Integer:
+			return &object.Float{Value: float64(arg.Value)}, nil
+		case *object.String:
+			value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+			if err != nil {
+				return nil, newError("could not convert %q to FLOAT", arg.Value)
 			}
-			if args[0].Type() != object.ARRAY_OBJ {
-				return newError("argument to `push` must be ARRAY, got %s",
-					args[0].Type())
+			return &object.Float{Value: value}, nil
+		default:
+			return nil, object.ArgumentError("float", 1, "INTEGER, FLOAT or STRING", arg)
+		}
+	})
+
+	mustRegister("int", func(arg object.Object) (object.Object, error) {
+		switch arg := arg.(type) {
+		case *object.Integer:
+			return arg, nil
+		case *object.Float:
+			// Truncates toward zero, like go does
+			if math.IsNaN(arg.Value) || arg.Value < math.MinInt64 || arg.Value >= math.MaxInt64 {
+				return nil, newError("float %s out of INTEGER range", arg.Inspect())
 			}
-			arr := args[0].(*object.Array)
-			length := len(arr.Elements)
-			newElements := make([]object.Object, length+1)
-			copy(newElements, arr.Elements)
-			newElements[length] = args[1]
-			return &object.Array{Elements: newElements}
-		},
-	},
-	"put": {
-		Fn: func(args ...object.Object) object.Object {
-			for _, args := range args {
-				fmt.Println(args.Inspect())
+			return &object.Integer{Value: int64(arg.Value)}, nil
+		case *object.String:
+			value, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+			if err != nil {
+				return nil, newError("could not convert %q to INTEGER", arg.Value)
 			}
-			return NULL
-		},
-	},
+			return &object.Integer{Value: value}, nil
+		default:
+			return nil, object.ArgumentError("int", 1, "INTEGER, FLOAT or STRING", arg)
+		}
+	})
+
+	mustRegister("put", func(ctx object.CallContext, args ...object.Object) {
+		for _, arg := range args {
+			fmt.Fprintln(ctx.Out(), arg.Inspect())
+		}
+	})
+}
+
+// RegisterBuiltin makes the Go function fn available as the builtin name to
+// every program using the default builtins, see object.NewBuiltin for the
+// functions accepted
+func RegisterBuiltin(name string, fn any) error {
+	return builtins.Register(name, fn)
+}
+
+// DefaultBuiltins returns a copy of the default builtins, to customize for
+// an environment through its Runtime.Builtins
+func DefaultBuiltins() *object.Registry {
+	return builtins.Clone()
+}
+
+func builtinsOf(env *object.Environment) *object.Registry {
+	if registry := env.Runtime().Builtins; registry != nil {
+		return registry
+	}
+	return builtins
+}
+
+func mustRegister(name string, fn any) {
+	if err := RegisterBuiltin(name, fn); err != nil {
+		panic(err)
+	}
 }
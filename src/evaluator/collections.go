@@ -0,0 +1,316 @@
+package evaluator
+
+import (
+	"sort"
+
+	"monkey/src/object"
+)
+
+/*
+  Builtins working on arrays. None of them changes the array it is given,
+  they return a new one. Functions given to them are called with the
+  elements in order. map and reduce use what they return, filter, find,
+  any, all and the comparator of sort only its truthiness:
+
+    map([1, 2, 3], fn(x) { x * 2 })             [2, 4, 6]
+    filter([1, 2, 3], fn(x) { x % 2 == 1 })     [1, 3]
+    reduce([1, 2, 3], fn(acc, x) { acc + x })   6
+    sort([3, 1, 2], fn(a, b) { a > b })         [3, 2, 1]
+*/
+
+// The largest array range builds, so that a single call can not exhaust the
+// memory of the host
+const maxRangeLength = 1_000_000
+
+func init() {
+	mustRegister("map", func(ctx object.CallContext, arr *object.Array, fn object.Object) (object.Object, error) {
+		if err := checkCallable("map", 2, fn); err != nil {
+			return nil, err
+		}
+
+		elements := make([]object.Object, len(arr.Elements))
+		for i, element := range arr.Elements {
+			result := ctx.Apply(fn, element)
+			if isError(result) {
+				return result, nil
+			}
+			elements[i] = result
+		}
+		return &object.Array{Elements: elements}, nil
+	})
+
+	mustRegister("filter", func(ctx object.CallContext, arr *object.Array, fn object.Object) (object.Object, error) {
+		if err := checkCallable("filter", 2, fn); err != nil {
+			return nil, err
+		}
+
+		elements := []object.Object{}
+		for _, element := range arr.Elements {
+			result := ctx.Apply(fn, element)
+			if isError(result) {
+				return result, nil
+			}
+			if isTruthy(result) {
+				elements = append(elements, element)
+			}
+		}
+		return &object.Array{Elements: elements}, nil
+	})
+
+	// reduce(arr, fn, initial) folds arr from the left, without initial the
+	// first element is used instead
+	mustRegister("reduce", func(ctx object.CallContext, arr *object.Array, fn object.Object, initial ...object.Object) (object.Object, error) {
+		if err := checkCallable("reduce", 2, fn); err != nil {
+			return nil, err
+		}
+		if len(initial) > 1 {
+			return nil, newError("builtin reduce expects at most 3 arguments, got %d", len(initial)+2)
+		}
+
+		elements := arr.Elements
+		var acc object.Object
+		if len(initial) == 1 {
+			acc = initial[0]
+		} else if len(elements) > 0 {
+			acc, elements = elements[0], elements[1:]
+		} else {
+			return nil, newError("`reduce` of an empty ARRAY needs an initial value")
+		}
+
+		for _, element := range elements {
+			acc = ctx.Apply(fn, acc, element)
+			if isError(acc) {
+				return acc, nil
+			}
+		}
+		return acc, nil
+	})
+
+	// sort(arr, less) keeps equal elements in order. Without less, numbers
+	// and strings are sorted in increasing order
+	mustRegister("sort", func(ctx object.CallContext, arr *object.Array, less ...object.Object) (object.Object, error) {
+		if len(less) > 1 {
+			return nil, newError("builtin sort expects at most 2 arguments, got %d", len(less)+1)
+		}
+		if len(less) == 1 {
+			if err := checkCallable("sort", 2, less[0]); err != nil {
+				return nil, err
+			}
+		}
+
+		elements := make([]object.Object, len(arr.Elements))
+		copy(elements, arr.Elements)
+
+		// The first error stops the comparisons, sort.SliceStable can not be
+		// interrupted so the remaining ones are skipped
+		var err object.Object
+		sort.SliceStable(elements, func(i, j int) bool {
+			if err != nil {
+				return false
+			}
+
+			if len(less) == 0 {
+				result, cmpErr := compare(elements[i], elements[j])
+				if cmpErr != nil {
+					err = cmpErr
+				}
+				return result < 0
+			}
+
+			result := ctx.Apply(less[0], elements[i], elements[j])
+			if isError(result) {
+				err = result
+				return false
+			}
+			return isTruthy(result)
+		})
+		if err != nil {
+			return err, nil
+		}
+
+		return &object.Array{Elements: elements}, nil
+	})
+
+	mustRegister("find", func(ctx object.CallContext, arr *object.Array, fn object.Object) (object.Object, error) {
+		if err := checkCallable("find", 2, fn); err != nil {
+			return nil, err
+		}
+
+		for _, element := range arr.Elements {
+			result := ctx.Apply(fn, element)
+			if isError(result) {
+				return result, nil
+			}
+			if isTruthy(result) {
+				return element, nil
+			}
+		}
+		return NULL, nil
+	})
+
+	mustRegister("any", func(ctx object.CallContext, arr *object.Array, fn object.Object) (object.Object, error) {
+		return quantify(ctx, "any", arr, fn, true)
+	})
+
+	mustRegister("all", func(ctx object.CallContext, arr *object.Array, fn object.Object) (object.Object, error) {
+		return quantify(ctx, "all", arr, fn, false)
+	})
+
+	// zip(a, b, ...) pairs up the elements at the same index, stopping at the
+	// end of the shortest array
+	mustRegister("zip", func(first *object.Array, others ...*object.Array) *object.Array {
+		arrays := append([]*object.Array{first}, others...)
+
+		length := len(first.Elements)
+		for _, arr := range others {
+			length = min(length, len(arr.Elements))
+		}
+
+		elements := make([]object.Object, length)
+		for i := range elements {
+			tuple := make([]object.Object, len(arrays))
+			for j, arr := range arrays {
+				tuple[j] = arr.Elements[i]
+			}
+			elements[i] = &object.Array{Elements: tuple}
+		}
+		return &object.Array{Elements: elements}
+	})
+
+	// range(end), range(start, end) and range(start, end, step) count from
+	// start, 0 by default, up to end excluded. Each integer counts as a step
+	// against the budget of the evaluation
+	mustRegister("range", func(ctx object.CallContext, bounds ...int64) (*object.Array, error) {
+		start, end, step := int64(0), int64(0), int64(1)
+		switch len(bounds) {
+		case 1:
+			end = bounds[0]
+		case 2:
+			start, end = bounds[0], bounds[1]
+		case 3:
+			start, end, step = bounds[0], bounds[1], bounds[2]
+		default:
+			return nil, newError("builtin range expects 1 to 3 arguments, got %d", len(bounds))
+		}
+		if step == 0 {
+			return nil, newError("`range` step can not be 0")
+		}
+
+		length := rangeLength(start, end, step)
+		if length > maxRangeLength {
+			return nil, newError("`range` of %d integers exceeds the limit of %d", length, maxRangeLength)
+		}
+		if err := steps(ctx.Env().Runtime(), int(length)); err != nil {
+			return nil, err
+		}
+
+		elements := make([]object.Object, length)
+		for i := range elements {
+			elements[i] = &object.Integer{Value: start + int64(i)*step}
+		}
+		return &object.Array{Elements: elements}, nil
+	})
+
+	mustRegister("reverse", func(arr *object.Array) *object.Array {
+		length := len(arr.Elements)
+		elements := make([]object.Object, length)
+		for i, element := range arr.Elements {
+			elements[length-1-i] = element
+		}
+		return &object.Array{Elements: elements}
+	})
+
+	// flatten removes a single level of nesting: [[1], [2, [3]], 4] gives
+	// [1, 2, [3], 4]
+	mustRegister("flatten", func(arr *object.Array) *object.Array {
+		elements := []object.Object{}
+		for _, element := range arr.Elements {
+			if nested, ok := element.(*object.Array); ok {
+				elements = append(elements, nested.Elements...)
+			} else {
+				elements = append(elements, element)
+			}
+		}
+		return &object.Array{Elements: elements}
+	})
+}
+
+// rangeLength is the number of integers from start up to end excluded by
+// step. The distance between them is computed unsigned as it may not fit an
+// int64, counting instead would overflow near the bounds of int64
+func rangeLength(start, end, step int64) uint64 {
+	switch {
+	case step > 0 && start < end:
+		return (uint64(end)-uint64(start)-1)/uint64(step) + 1
+	case step < 0 && start > end:
+		return (uint64(start)-uint64(end)-1)/(-uint64(step)) + 1
+	default:
+		return 0
+	}
+}
+
+// checkCallable reports the nth argument of the builtin name not being a
+// function it can call
+func checkCallable(name string, n int, fn object.Object) *object.Error {
+	switch fn.(type) {
+	case *object.Function, *object.Builtin:
+		return nil
+	default:
+		return object.ArgumentError(name, n, "FUNCTION or BUILTIN", fn)
+	}
+}
+
+// quantify tells if fn is truthy for some element of arr, or for all of them
+// when some is false. It stops at the first element deciding the result
+func quantify(ctx object.CallContext, name string, arr *object.Array, fn object.Object, some bool) (object.Object, error) {
+	if err := checkCallable(name, 2, fn); err != nil {
+		return nil, err
+	}
+
+	for _, element := range arr.Elements {
+		result := ctx.Apply(fn, element)
+		if isError(result) {
+			return result, nil
+		}
+		if isTruthy(result) == some {
+			return nativeBoolToBooleanObject(some), nil
+		}
+	}
+	return nativeBoolToBooleanObject(!some), nil
+}
+
+// compare orders numbers and strings for sort, it is negative when left
+// comes first
+func compare(left, right object.Object) (int, *object.Error) {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		l, r := left.(*object.Integer).Value, right.(*object.Integer).Value
+		switch {
+		case l < r:
+			return -1, nil
+		case l > r:
+			return 1, nil
+		}
+		return 0, nil
+	case isNumber(left) && isNumber(right):
+		l, r := toFloat(left), toFloat(right)
+		switch {
+		case l < r:
+			return -1, nil
+		case l > r:
+			return 1, nil
+		}
+		return 0, nil
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		l, r := left.(*object.String).Value, right.(*object.String).Value
+		switch {
+		case l < r:
+			return -1, nil
+		case l > r:
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		return 0, newError("`sort` can not compare %s with %s", left.Type(), right.Type())
+	}
+}
@@ -1,13 +1,128 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime/debug"
 
 	"monkey/src/ast"
 	"monkey/src/object"
+	"monkey/src/token"
 )
 
+// ErrBudgetExceeded is the Cause of the error returned when an evaluation
+// runs out of steps
+var ErrBudgetExceeded = errors.New("execution budget exceeded")
+
+// How many steps are evaluated between two checks of the context
+const contextCheckInterval = 1024
+
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if err := step(env.Runtime()); err != nil {
+		return err
+	}
+
+	result := eval(node, env)
+
+	// The innermost node that failed is the one the error is about, errors
+	// coming from deeper nodes already carry their position and call stack
+	if err, ok := result.(*object.Error); ok {
+		if !err.Pos.IsValid() {
+			err.Pos = node.Pos()
+			err.End = node.End()
+		}
+		if err.Trace == nil {
+			err.Trace = env.Runtime().Trace()
+		}
+	}
+
+	return result
+}
+
+// EvalContext is SafeEval stopping with an error when ctx is done or after
+// budget nodes have been evaluated, a budget of 0 is unlimited. The Cause of
+// the error is ctx.Err() or ErrBudgetExceeded
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, budget int) object.Object {
+	rt := env.Runtime()
+
+	savedContext, savedBudget, savedSteps := rt.Context, rt.StepBudget, rt.Steps
+	defer func() {
+		rt.Context, rt.StepBudget, rt.Steps = savedContext, savedBudget, savedSteps
+	}()
+	rt.Context, rt.StepBudget, rt.Steps = ctx, budget, 0
+
+	if err := ctx.Err(); err != nil {
+		return stopped(err)
+	}
+	return SafeEval(node, env)
+}
+
+// step counts one more evaluated node, it returns an error once the
+// evaluation has to stop
+func step(rt *object.Runtime) *object.Error {
+	return steps(rt, 1)
+}
+
+// steps counts n steps at once, for builtins doing as much work as
+// evaluating n nodes
+func steps(rt *object.Runtime, n int) *object.Error {
+	before := rt.Steps
+	rt.Steps += n
+
+	if rt.StepBudget > 0 && rt.Steps > rt.StepBudget {
+		return stopped(ErrBudgetExceeded)
+	}
+	if rt.Context != nil && rt.Steps/contextCheckInterval != before/contextCheckInterval {
+		if err := rt.Context.Err(); err != nil {
+			return stopped(err)
+		}
+	}
+
+	return nil
+}
+
+func stopped(cause error) *object.Error {
+	return &object.Error{
+		Message: "evaluation stopped: " + cause.Error(),
+		Cause:   cause,
+	}
+}
+
+// SafeEval is Eval for code that must survive anything the program does: a
+// Go panic while evaluating is recovered and returned as an internal
+// *object.Error instead of crashing the host. Bindings made before the
+// failure stay in env
+func SafeEval(node ast.Node, env *object.Environment) (result object.Object) {
+	defer recoverInternal(&result)
+
+	return Eval(node, env)
+}
+
+// Apply calls fn with args from env like a call expression does, for host
+// programs calling into Monkey. Panics are recovered like in SafeEval
+func Apply(fn object.Object, args []object.Object, env *object.Environment) (result object.Object) {
+	defer recoverInternal(&result)
+
+	return trampoline(&tailCall{fn: fn, args: args, env: env})
+}
+
+// recoverInternal must be deferred, it turns a panic into an internal error
+// stored in result
+func recoverInternal(result *object.Object) {
+	if r := recover(); r != nil {
+		*result = &object.Error{
+			Message:  fmt.Sprintf("internal error: %v", r),
+			Internal: true,
+			Stack:    string(debug.Stack()),
+		}
+	}
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(node, env)
@@ -15,6 +130,8 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.Identifier:
@@ -34,6 +151,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return evalPrefixExpression(node.Operator, right)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
+
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -44,7 +165,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return right
 		}
 
-		return evalInfixExpression(node.Operator, left, right)
+		return evalInfixExpression(node, left, right)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -63,6 +184,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		params := node.Parameters
 		body := node.Body
 		return &object.Function{
+			Name:       node.Name,
 			Parameters: params,
 			Body:       body,
 			Env:        env,
@@ -74,16 +196,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		}
 		return &object.ReturnValue{Value: val}
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
-			return function
-		}
-
-		args := evalExpression(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
-			return args[0]
-		}
-		return applyFunction(function, args)
+		return trampoline(evalTail(node, env, true))
 	case *ast.ArrayLiteral:
 		elements := evalExpression(node.Elements, env)
 		if len(elements) == 1 && isError(elements[0]) {
@@ -93,8 +206,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return &object.Array{Elements: elements}
 	case *ast.HashLiteral:
 		return evalHashLiteral(node, env)
+	case *ast.BadExpression, *ast.BadStatement:
+		return newError("malformed code can not be evaluated: %s", node.TokenLiteral())
 	default:
-		panic(fmt.Sprintf("unexpected ast.Node: %#v", node))
+		return &object.Error{
+			Message:  fmt.Sprintf("unexpected node: %T", node),
+			Internal: true,
+		}
 	}
 	return nil
 }
@@ -107,7 +225,7 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
-			return result.Value
+			return trampoline(result.Value)
 		case *object.Error:
 			return result
 		}
@@ -130,21 +248,77 @@ func evalExpression(exps []ast.Expression, env *object.Environment) []object.Obj
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction calls fn from env, the environment of the call site, node is
+// the call if there is one. The result may be a *tailCall left for
+// trampoline to apply
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment, node *ast.CallExpression) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		if len(args) != len(fn.Parameters) {
+			return arityError(fn, len(args))
+		}
+
+		extendedEnv := extendFunctionEnv(fn, args, env)
+		evaluated := evalTail(fn.Body, extendedEnv, true)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
+		if fn.Call != nil {
+			return fn.Call(&callContext{env: env, node: node}, args...)
+		}
 		return fn.Fn(args...)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(function *object.Function, args []object.Object) *object.Environment {
-	env := object.NewEnclosedEnvironment(function.Env)
+// callContext is the object.CallContext of a builtin called by node from
+// env, functions it applies are seen as called by node too
+type callContext struct {
+	env  *object.Environment
+	node *ast.CallExpression
+}
+
+func (ctx *callContext) Apply(fn object.Object, args ...object.Object) object.Object {
+	result := trampoline(&tailCall{node: ctx.node, fn: fn, args: args, env: ctx.env})
+	// An empty function body evaluates to nil, builtins get NULL instead
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+func (ctx *callContext) Out() io.Writer {
+	if out := ctx.env.Runtime().Stdout; out != nil {
+		return out
+	}
+	return os.Stdout
+}
+
+func (ctx *callContext) Env() *object.Environment {
+	return ctx.env
+}
+
+// arityError reports a call with the wrong number of arguments, the position
+// is the one of the call expression
+func arityError(fn *object.Function, got int) *object.Error {
+	name := functionName(fn)
+
+	want := len(fn.Parameters)
+	if want == 1 {
+		return newError("%s expects 1 argument, got %d", name, got)
+	}
+	return newError("%s expects %d arguments, got %d", name, want, got)
+}
+
+func functionName(fn object.Object) string {
+	if fn, ok := fn.(*object.Function); ok && fn.Name != "" {
+		return "function " + fn.Name
+	}
+	return "function"
+}
+
+func extendFunctionEnv(function *object.Function, args []object.Object, caller *object.Environment) *object.Environment {
+	env := object.NewCallEnvironment(function.Env, caller)
 
 	for paramIdx, param := range function.Parameters {
 		env.Set(param.Value, args[paramIdx])
@@ -182,7 +356,7 @@ func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := builtinsOf(env).Get(node.Value); ok {
 		return builtin
 	}
 
@@ -214,6 +388,8 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusOperatorExpression(right)
+	case "~":
+		return evalBitwiseNotExpression(right)
 	default:
 		return newError("unknown operation: %s%s", operator, right.Type())
 	}
@@ -285,11 +461,22 @@ func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Obje
 }
 
 func evalMinusOperatorExpression(exp object.Object) object.Object {
-	if exp.Type() != object.INTEGER_OBJ {
+	switch exp := exp.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: -exp.Value}
+	case *object.Float:
+		return &object.Float{Value: -exp.Value}
+	default:
 		return newError("unknown operation: -%s", exp.Type())
 	}
+}
+
+func evalBitwiseNotExpression(exp object.Object) object.Object {
+	if exp.Type() != object.INTEGER_OBJ {
+		return newError("unknown operation: ~%s", exp.Type())
+	}
 	value := exp.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+	return &object.Integer{Value: ^value}
 }
 
 func evalBangOperatorExpression(exp object.Object) object.Object {
@@ -305,10 +492,15 @@ func evalBangOperatorExpression(exp object.Object) object.Object {
 	}
 }
 
-func evalInfixExpression(operator string, left, right object.Object) object.Object {
+func evalInfixExpression(node *ast.InfixExpression, left, right object.Object) object.Object {
+	operator := node.Operator
+
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
-		return evalIntegerInfixExpression(operator, left, right)
+		return evalIntegerInfixExpression(node, left, right)
+	case isNumber(left) && isNumber(right):
+		// An integer mixed with a float is promoted to float
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("type missmatch: %s %s %s", left.Type(), operator, right.Type())
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
@@ -328,6 +520,15 @@ func newError(format string, a ...interface{}) *object.Error {
 	}
 }
 
+// newErrorAt is newError pointing at tok instead of the whole failing node
+func newErrorAt(tok token.Token, format string, a ...interface{}) *object.Error {
+	return &object.Error{
+		Message: fmt.Sprintf(format, a...),
+		Pos:     tok.Pos,
+		End:     tok.End,
+	}
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
@@ -335,6 +536,23 @@ func isError(obj object.Object) bool {
 	return false
 }
 
+// evalLogicalExpression evaluates && and || lazily, the right side is only
+// evaluated when the left one does not decide the result. The result is the
+// last operand evaluated, not necessarily a boolean: `if (false) { 1 } || 5`
+// is 5
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+
+	if isTruthy(left) == (node.Operator == "||") {
+		return left
+	}
+
+	return Eval(node.Right, env)
+}
+
 func evalIfExpression(node *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(node.Condition, env)
 	if isError(condition) {
@@ -373,7 +591,11 @@ func evalStringInfix(operator string, left, right object.Object) object.Object {
 	return &object.String{Value: leftVal + rightVal}
 }
 
-func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
+// Integer arithmetic wraps around on overflow like Go does, this includes
+// math.MinInt64 / -1 which is math.MinInt64 again (and math.MinInt64 % -1
+// is 0). Dividing by zero is an error pointing at the operator
+func evalIntegerInfixExpression(node *ast.InfixExpression, left, right object.Object) object.Object {
+	operator := node.Operator
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 
@@ -385,11 +607,103 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "*":
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
+		if rightVal == 0 {
+			return newErrorAt(node.Token, "division by zero: %d / 0", leftVal)
+		}
 		return &object.Integer{Value: leftVal / rightVal}
+	case "%":
+		// The result has the sign of the left operand: -7 % 3 is -1
+		if rightVal == 0 {
+			return newErrorAt(node.Token, "modulo by zero: %d %% 0", leftVal)
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "**":
+		return evalIntegerPower(leftVal, rightVal)
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		return evalShiftExpression(operator, leftVal, rightVal)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operation: %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalIntegerPower raises base to exp. A negative exponent gives a float,
+// 2 ** -1 is 0.5, otherwise the result is an integer that wraps around on
+// overflow like the other integer operations
+func evalIntegerPower(base, exp int64) object.Object {
+	if exp < 0 {
+		return &object.Float{Value: math.Pow(float64(base), float64(exp))}
+	}
+
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return &object.Integer{Value: result}
+}
+
+// Shifts by 64 or more are allowed: << gives 0 and >> gives 0 or -1 as the
+// sign is kept
+func evalShiftExpression(operator string, leftVal, rightVal int64) object.Object {
+	if rightVal < 0 {
+		return newError("negative shift count: %d", rightVal)
+	}
+
+	if operator == "<<" {
+		return &object.Integer{Value: leftVal << uint64(rightVal)}
+	}
+	return &object.Integer{Value: leftVal >> uint64(rightVal)}
+}
+
+// Float arithmetic follows IEEE 754, dividing by zero gives an infinity or
+// NaN rather than an error. % is math.Mod, the result has the sign of the
+// left operand like for integers
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
+	case "/":
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -399,8 +713,24 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	}
 }
 
+func isNumber(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.FLOAT_OBJ
+}
+
+// toFloat converts an Integer or a Float to a float64
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.Float:
+		return obj.Value
+	default:
+		return math.NaN()
+	}
+}
+
 var (
-	NULL  = &object.Null{}
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
+	NULL  = object.NULL
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
 )
@@ -1,8 +1,15 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"monkey/src/ast"
 	"monkey/src/lexer"
 	"monkey/src/object"
 	"monkey/src/parser"
@@ -112,6 +119,42 @@ func testBooleanObject(t *testing.T, evaluated object.Object, expected bool) boo
 	return true
 }
 
+// testExpectedObject checks evaluated against expected: an int, float64 or
+// bool value, nil for NULL, or a string for the message of an error or the
+// value of a string
+func testExpectedObject(t *testing.T, evaluated object.Object, expected interface{}) bool {
+	switch expected := expected.(type) {
+	case int:
+		return testIntegerObject(t, evaluated, int64(expected))
+	case float64:
+		return testFloatObject(t, evaluated, expected)
+	case bool:
+		return testBooleanObject(t, evaluated, expected)
+	case nil:
+		return testNullObject(t, evaluated)
+	case string:
+		switch result := evaluated.(type) {
+		case *object.Error:
+			if result.Message != expected {
+				t.Errorf("wrong error message, expected: %s, got: %s", expected, result.Message)
+				return false
+			}
+		case *object.String:
+			if result.Value != expected {
+				t.Errorf("String wrong, expected: %s, got: %s", expected, result.Value)
+				return false
+			}
+		default:
+			t.Errorf("Expected Error Object, got: %T (%+v)", evaluated, evaluated)
+			return false
+		}
+		return true
+	default:
+		t.Fatalf("unsupported expected value: %T", expected)
+		return false
+	}
+}
+
 func TestBangOperator(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -332,6 +375,35 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestFunctionArity(t *testing.T) {
+	tests := []struct {
+		input         string
+		expected      string
+		expectedStart string
+	}{
+		{"let add = fn(a, b) { a + b };\nadd(1)", "function add expects 2 arguments, got 1", "2:1"},
+		{"let add = fn(a, b) { a + b }; add(1, 2, 3)", "function add expects 2 arguments, got 3", "1:31"},
+		{"let id = fn(x) { x }; id()", "function id expects 1 argument, got 0", "1:23"},
+		{"fn() { 1 }(2)", "function expects 0 arguments, got 1", "1:1"},
+		{"let f = fn(g) { g(1, 2) }; f(fn(x) { x })", "function expects 1 argument, got 2", "1:17"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errorObject, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if errorObject.Message != tt.expected {
+			t.Errorf("wrong error message, expected: %s, got: %s", tt.expected, errorObject.Message)
+		}
+		if errorObject.Pos.String() != tt.expectedStart {
+			t.Errorf("input %q: wrong Pos. Expected: %s, got: %s", tt.input, tt.expectedStart, errorObject.Pos)
+		}
+	}
+}
+
 func TestClosure(t *testing.T) {
 	input := `
     let newAdder = fn(x) {
@@ -383,26 +455,64 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello worlds")`, 12},
-		{`len(1)`, "argument to `len` not supported: INTEGER"},
-		{`len(1,2)`, "wrong number of arguments. Got: 2, take: 1"},
+		{`len([1, 2])`, 2},
+		{`len(1)`, "argument 1 to `len` must be STRING or ARRAY, got INTEGER"},
+		{`len(1,2)`, "builtin len expects 1 argument, got 2"},
+		{`first([1, 2])`, 1},
+		{`first([])`, nil},
+		{`first(1)`, "argument 1 to `first` must be ARRAY, got INTEGER"},
+		{`last([1, 2])`, 2},
+		{`last("ab")`, "argument 1 to `last` must be ARRAY, got STRING"},
+		{`len(rest([1, 2, 3]))`, 2},
+		{`rest([])`, nil},
+		{`rest()`, "builtin rest expects 1 argument, got 0"},
+		{`last(push([1], 2))`, 2},
+		{`push([1])`, "builtin push expects 2 arguments, got 1"},
+		{`push(1, 2)`, "argument 1 to `push` must be ARRAY, got INTEGER"},
+		{`put(1, "a")`, nil},
+		// Empty function bodies evaluate to nil
+		{`let f = fn() {}; len(f())`, "argument 1 to `len` must be STRING or ARRAY, got NULL"},
+		{`let f = fn() {}; push([], f())[0] == first([])`, true},
 	}
 
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
-		switch expected := tt.expected.(type) {
-		case int:
-			testIntegerObject(t, evaluated, int64(expected))
-		case string:
-			errorObject, ok := evaluated.(*object.Error)
-			if !ok {
-				t.Errorf("Expected Error Object, got: %T (%+v)", evaluated, evaluated)
-				continue
-			}
-			if errorObject.Message != expected {
-				t.Errorf("wrong error message, expected: %s, got: %s", expected, errorObject.Message)
-			}
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	defer builtins.Unregister("repeat")
+	err := RegisterBuiltin("repeat", func(s string, n int64) (string, error) {
+		if n < 0 {
+			return "", errors.New("negative count")
+		}
+		return strings.Repeat(s, int(n)), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", -1)`, "ERROR: negative count"},
+		{`repeat("ab")`, "ERROR: builtin repeat expects 2 arguments, got 1"},
+		{`repeat(3, "ab")`, "ERROR: argument 1 to `repeat` must be STRING, got INTEGER"},
+		{`repeat("ab", 1.5)`, "ERROR: argument 2 to `repeat` must be INTEGER, got FLOAT"},
+	}
+
+	for _, tt := range tests {
+		if evaluated := testEval(tt.input); evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. Expected: %s, got: %s", tt.input, tt.expected, evaluated.Inspect())
 		}
 	}
+
+	if err := RegisterBuiltin("bad", 42); err == nil || err.Error() != "builtin bad: not a function: int" {
+		t.Errorf("wrong error, got: %v", err)
+	}
 }
 
 func TestArrayLiterals(t *testing.T) {
@@ -565,3 +675,633 @@ func TestHandleHashExpression(t *testing.T) {
 		}
 	}
 }
+
+func TestEvalMalformedProgram(t *testing.T) {
+	evaluated := testEval("let x = 1; let y 2;")
+
+	errorObject, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+	}
+
+	expected := "malformed code can not be evaluated: let"
+	if errorObject.Message != expected {
+		t.Errorf("Wrong error message. Expected: %s, got: %s", expected, errorObject.Message)
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedStart string
+		expectedEnd   string
+	}{
+		{"5 + true", "1:1", "1:9"},
+		{"let x = 1;\nfoobar", "2:1", "2:7"},
+		{"let f = fn(x) { -x };\nf(true)", "1:17", "1:19"},
+		{"if (1 < 2) { [1][true + 1] }", "1:18", "1:26"},
+		{"let x = 0;\n10 / x", "2:4", "2:5"},
+		{"(1 + 2) % (1 - 1)", "1:9", "1:10"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errorObject, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		if errorObject.Pos.String() != tt.expectedStart {
+			t.Errorf("input %q: wrong Pos. Expected: %s, got: %s", tt.input, tt.expectedStart, errorObject.Pos)
+		}
+		if errorObject.End.String() != tt.expectedEnd {
+			t.Errorf("input %q: wrong End. Expected: %s, got: %s", tt.input, tt.expectedEnd, errorObject.End)
+		}
+	}
+}
+
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"10 / 4.0", 2.5},
+		{"2 * 1.25 - 1", 1.5},
+		{"let avg = fn(a, b) { (a + b) / 2.0 }; avg(3, 4)", 3.5},
+		{"float(3)", 3},
+		{`float(" 2.75 ")`, 2.75},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func testFloatObject(t *testing.T, evaluated object.Object, expected float64) bool {
+	result, ok := evaluated.(*object.Float)
+	if !ok {
+		t.Errorf("Object is not Float Object, got: %T (%+v)", evaluated, evaluated)
+		return false
+	}
+
+	if result.Value != expected {
+		t.Errorf("Float Object value wrong, expected: %g, got: %g", expected, result.Value)
+		return false
+	}
+
+	return true
+}
+
+func TestFloatComparisonsAndConversions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1 == 1.0", true},
+		{"1.0 != 1", false},
+		{"0.1 + 0.2 == 0.3", false},
+		{"int(3.99)", 3},
+		{"int(-3.99)", -3},
+		{`int("42")`, 42},
+		{`{1: "one"}[1.0]`, "one"},
+		{`{2.5: "x"}[2.5]`, "x"},
+		{`int("4.2")`, `could not convert "4.2" to INTEGER`},
+		{`float(true)`, "argument 1 to `float` must be INTEGER, FLOAT or STRING, got BOOLEAN"},
+		{`int(1e300)`, "float 1e+300 out of INTEGER range"},
+		{`1.5 + true`, "type missmatch: FLOAT + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"12 & 10", 8},
+		{"12 | 10", 14},
+		{"12 ^ 10", 6},
+		{"~0", -1},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"256 >> 4", 16},
+		{"-16 >> 2", -4},
+		{"1 << 64", 0},
+		{"-1 >> 100", -1},
+		{"0xFF & ~0x0F", 0xF0},
+		{"1 + 2 << 3", 17},
+		{"2 * 3 & 5", 4},
+		{"6 | 1 == 7", true},
+		{"let READ = 4; let WRITE = 2; let mode = READ | WRITE; mode & WRITE == WRITE", true},
+		{"1 << -1", "negative shift count: -1"},
+		{"~true", "unknown operation: ~BOOLEAN"},
+		{"~1.5", "unknown operation: ~FLOAT"},
+		{"1 & 1.5", "unknown operation: INTEGER & FLOAT"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"false && foobar", false},
+		{"true || foobar", true},
+		{"1 && 2", 2},
+		{"0 || 7", 0},
+		{"if (false) { 1 } || 5", 5},
+		{"if (false) { 1 } && 5", nil},
+		{"true && foobar", "identifier not found: `foobar`"},
+		{"foobar || true", "identifier not found: `foobar`"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestComparisonAndArithmeticOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"2 >= 2", true},
+		{"1 >= 2", false},
+		{"1.5 <= 1", false},
+		{"2 >= 1.5", true},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"7 % -3", 1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"10 ** 0", 1},
+		{"2 ** -1", 0.5},
+		{"2.0 ** 0.5 * 2.0 ** 0.5", 2.0000000000000004},
+		{"7.5 % 2", 1.5},
+		{"-7.5 % 2", -1.5},
+		{"5 % 0", "modulo by zero: 5 % 0"},
+		{`"a" <= "b"`, "unknown operation: STRING <= STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestIntegerDivision(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"7 / 2", 3},
+		{"-7 / 2", -3},
+		{"1 / 0", "division by zero: 1 / 0"},
+		{"let zero = 0; -5 / zero", "division by zero: -5 / 0"},
+		{"1 % 0", "modulo by zero: 1 % 0"},
+		{"1.0 / 0 > 1000000", true},
+		// Overflow wraps around like in Go
+		{"let min = -9223372036854775807 - 1; min / -1 == min", true},
+		{"let min = -9223372036854775807 - 1; min % -1", 0},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+type unknownNode struct{ ast.Identifier }
+
+func TestSafeEvalRecoversPanics(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Runtime().Builtins = DefaultBuiltins()
+	env.Runtime().Builtins.Set("explode", &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			var arr []object.Object
+			return arr[len(args)]
+		},
+	})
+
+	program := parser.New(lexer.New("let x = 5; explode(1)")).ParseProgram()
+
+	evaluated := SafeEval(program, env)
+	errorObject, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+	}
+	if !errorObject.Internal {
+		t.Errorf("error is not flagged as internal")
+	}
+	if !strings.HasPrefix(errorObject.Message, "internal error: runtime error: index out of range") {
+		t.Errorf("wrong error message, got: %s", errorObject.Message)
+	}
+	if !strings.Contains(errorObject.Stack, "panic") {
+		t.Errorf("error does not carry the Go stack, got: %q", errorObject.Stack)
+	}
+
+	// The environment is still usable after the failure
+	program = parser.New(lexer.New("x * 2")).ParseProgram()
+	testIntegerObject(t, SafeEval(program, env), 10)
+}
+
+func TestEvalUnknownNode(t *testing.T) {
+	evaluated := SafeEval(&unknownNode{}, object.NewEnvironment())
+
+	errorObject, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+	}
+	if !errorObject.Internal {
+		t.Errorf("error is not flagged as internal")
+	}
+	if errorObject.Message != "unexpected node: *evaluator.unknownNode" {
+		t.Errorf("wrong error message, got: %s", errorObject.Message)
+	}
+}
+
+func TestRecursionDepthLimit(t *testing.T) {
+	tests := []struct {
+		input        string
+		maxCallDepth int
+		expected     interface{}
+	}{
+		{"let f = fn(n) { 1 + f(n + 1) }; f(0)", 0, "maximum recursion depth exceeded: 10000 calls deep in function f"},
+		{"let f = fn(n) { 1 + f(n + 1) }; f(0)", 50, "maximum recursion depth exceeded: 50 calls deep in function f"},
+		{"fn(g) { 1 + g(g) }(fn(g) { 1 + g(g) })", 50, "maximum recursion depth exceeded: 50 calls deep in function"},
+		{"let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } }; count(50)", 51, 50},
+		// The depth goes back down once calls return
+		{"let count = fn(n) { if (n == 0) { 0 } else { 1 + count(n - 1) } }; count(40) + count(40)", 41, 80},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Runtime().MaxCallDepth = tt.maxCallDepth
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+
+		testExpectedObject(t, evaluated, tt.expected)
+
+		if env.Runtime().CallDepth() != 0 {
+			t.Errorf("call depth not restored, got: %d", env.Runtime().CallDepth())
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(1000000, 0)", 500000500000},
+		{"let sum = fn(n, acc) { if (n == 0) { return acc; } return sum(n - 1, acc + n); }; sum(100000, 0)", 5000050000},
+		{"let sum = fn(n, acc) { if (n > 0) { return sum(n - 1, acc + n); } acc }; sum(100000, 0)", 5000050000},
+		{`
+let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+even(100001)`, false},
+		{"let count = fn(n) { if (n == 0) { return len(\"done\") } count(n - 1) }; count(100000)", 4},
+		{"let f = fn(n) { if (n == 0) { g(1, 2) } else { f(n - 1) } }; let g = fn(x) { x }; f(100000)", "function g expects 1 argument, got 2"},
+		{"let f = fn(n) { if (n == 0) { n + true } else { f(n - 1) } }; f(100000)", "type missmatch: INTEGER + BOOLEAN"},
+	}
+
+	for _, tt := range tests {
+		env := object.NewEnvironment()
+		env.Runtime().MaxCallDepth = 100
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+
+		testExpectedObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestReturnInExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let f = fn() { put("called"); 1 }; let a = [if (true) { return f() }]; put(a)`, "called\n[1]\n"},
+		{`let f = fn() { put("called"); 1 }; let x = if (true) { return f() }; put(x)`, "called\n1\n"},
+		{`let f = fn() { put("called"); 1 }; let g = fn() { put([if (true) { return f() }]) }; g()`, "called\n[1]\n"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		env := object.NewEnvironment()
+		env.Runtime().Stdout = &out
+
+		evaluated := Eval(parser.New(lexer.New(tt.input)).ParseProgram(), env)
+		if isError(evaluated) {
+			t.Errorf("unexpected error for %s: %s", tt.input, evaluated.Inspect())
+			continue
+		}
+		if out.String() != tt.expected {
+			t.Errorf("wrong output for %s. Expected: %q, got: %q", tt.input, tt.expected, out.String())
+		}
+	}
+}
+
+func TestTailCallErrorPosition(t *testing.T) {
+	input := "let f = fn(n) {\n  if (n == 0) { g(1, 2) } else { f(n - 1) }\n};\nlet g = fn(x) { x };\nf(10)"
+
+	evaluated := testEval(input)
+	errorObject, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+	}
+	if errorObject.Pos.String() != "2:17" || errorObject.End.String() != "2:24" {
+		t.Errorf("wrong position. Expected: 2:17-2:24, got: %s-%s", errorObject.Pos, errorObject.End)
+	}
+}
+
+func TestErrorTrace(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string // Frames as "name@line:col", outermost first
+	}{
+		{"1 + true", []string{}},
+		{"let add = fn(a, b) { a + b };\nadd(1, true)", []string{"add@2:1"}},
+		{"let add = fn(a, b) { a + b };\nlet twice = fn(x) { add(x, x) + 0 };\ntwice(true)", []string{"twice@3:1", "add@2:21"}},
+		{"let f = fn(x) { fn(y) { y / 0 }(x) + 1 };\nf(1)", []string{"f@2:1", "<anonymous>@1:17"}},
+		// Errors about the call itself belong to the caller
+		{"let add = fn(a, b) { a + b };\nlet f = fn() { add(1) + 1 };\nf()", []string{"f@3:1"}},
+		// Tail calls replace the frame of their caller
+		{"let g = fn(x) { x + true };\nlet f = fn(x) { g(x) };\nf(1)", []string{"g@2:17"}},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errorObject, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+			continue
+		}
+
+		frames := []string{}
+		for _, frame := range errorObject.Trace {
+			frames = append(frames, fmt.Sprintf("%s@%s", frame.Name(), frame.Pos))
+		}
+		if strings.Join(frames, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("input %q: wrong trace. Expected: %v, got: %v", tt.input, tt.expected, frames)
+		}
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	tests := []struct {
+		ctx           context.Context
+		input         string
+		budget        int
+		expectedCause error
+	}{
+		{context.Background(), "let f = fn() { f() }; f()", 10000, ErrBudgetExceeded},
+		{context.Background(), "let f = fn(n) { 1 + f(n) }; f(1)", 500, ErrBudgetExceeded},
+		{canceled, "1 + 1", 0, context.Canceled},
+		{expired, "let f = fn() { f() }; f()", 0, context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(tt.ctx, program, object.NewEnvironment(), tt.budget)
+
+		errorObject, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+			continue
+		}
+		if !errors.Is(errorObject, tt.expectedCause) {
+			t.Errorf("input %q: wrong cause. Expected: %v, got: %v", tt.input, tt.expectedCause, errorObject.Cause)
+		}
+		if errorObject.Message != "evaluation stopped: "+tt.expectedCause.Error() {
+			t.Errorf("wrong error message, got: %s", errorObject.Message)
+		}
+	}
+}
+
+func TestEvalContextBudget(t *testing.T) {
+	env := object.NewEnvironment()
+	program := parser.New(lexer.New("let x = 1 + 2 * 3; x")).ParseProgram()
+
+	// let, infix, 1, infix, 2, 3, expression statement, x and the program
+	testIntegerObject(t, EvalContext(context.Background(), program, env, 9), 7)
+
+	evaluated := EvalContext(context.Background(), program, env, 8)
+	if errorObject, ok := evaluated.(*object.Error); !ok || errorObject.Cause != ErrBudgetExceeded {
+		t.Fatalf("expected budget error, got: %T (%+v)", evaluated, evaluated)
+	}
+
+	// The environment stays usable and without a budget afterwards
+	if env.Runtime().StepBudget != 0 || env.Runtime().Context != nil {
+		t.Errorf("runtime limits not restored")
+	}
+	testIntegerObject(t, Eval(parser.New(lexer.New("x")).ParseProgram(), env), 7)
+}
+
+func TestEvalContextBudgetRange(t *testing.T) {
+	env := object.NewEnvironment()
+
+	// The integers built count against the budget
+	program := parser.New(lexer.New("len(range(500))")).ParseProgram()
+	testIntegerObject(t, EvalContext(context.Background(), program, env, 1000), 500)
+
+	program = parser.New(lexer.New("len(range(1000))")).ParseProgram()
+	evaluated := EvalContext(context.Background(), program, env, 1000)
+	if errorObject, ok := evaluated.(*object.Error); !ok || errorObject.Cause != ErrBudgetExceeded {
+		t.Fatalf("expected budget error, got: %T (%+v)", evaluated, evaluated)
+	}
+
+	// Building them checks the context like evaluating as many nodes does
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env.Runtime().Context = ctx
+	evaluated = Eval(parser.New(lexer.New("range(5000)")).ParseProgram(), env)
+	if errorObject, ok := evaluated.(*object.Error); !ok || errorObject.Cause != context.Canceled {
+		t.Fatalf("expected canceled error, got: %T (%+v)", evaluated, evaluated)
+	}
+}
+
+func TestBuiltinCallContext(t *testing.T) {
+	var out bytes.Buffer
+	env := object.NewEnvironment()
+	env.Runtime().Stdout = &out
+	env.Runtime().Builtins = DefaultBuiltins()
+
+	env.Runtime().Builtins.Register("twice", func(ctx object.CallContext, fn object.Object, x object.Object) object.Object {
+		result := ctx.Apply(fn, x)
+		if isError(result) {
+			return result
+		}
+		return ctx.Apply(fn, result)
+	})
+	env.Runtime().Builtins.Set("lookup", &object.Builtin{
+		Call: func(ctx object.CallContext, args ...object.Object) object.Object {
+			value, ok := ctx.Env().Get(args[0].Inspect())
+			if !ok {
+				return NULL
+			}
+			return value
+		},
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"twice(fn(x) { x * 3 }, 2)", "18"},
+		{"twice(len, [1])", "ERROR: argument 1 to `len` must be STRING or ARRAY, got INTEGER"},
+		{"twice(fn(x, y) { x }, 2)", "ERROR: function expects 2 arguments, got 1"},
+		{"twice(fn(x) { x }, 2, 3)", "ERROR: builtin twice expects 2 arguments, got 3"},
+		{"let secret = 42; let f = fn() { let secret = 1; lookup(\"secret\") }; f()", "1"},
+		{`put("hello", 1)`, "null"},
+	}
+
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		if evaluated := Eval(program, env); evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %s. Expected: %s, got: %s", tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+
+	if out.String() != "hello\n1\n" {
+		t.Errorf("wrong output. Expected: %q, got: %q", "hello\n1\n", out.String())
+	}
+}
+
+func TestBuiltinCallbackTrace(t *testing.T) {
+	env := object.NewEnvironment()
+	env.Runtime().Builtins = DefaultBuiltins()
+	env.Runtime().Builtins.Register("call", func(ctx object.CallContext, fn object.Object) object.Object {
+		return ctx.Apply(fn)
+	})
+
+	input := "let bad = fn() { 1 + true };\nlet f = fn() { call(bad) + 1 };\nf()"
+	evaluated := Eval(parser.New(lexer.New(input)).ParseProgram(), env)
+
+	errorObject, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("No error object returned, got: %T (%+v)", evaluated, evaluated)
+	}
+	frames := []string{}
+	for _, frame := range errorObject.Trace {
+		frames = append(frames, fmt.Sprintf("%s@%s", frame.Name(), frame.Pos))
+	}
+	if strings.Join(frames, " ") != "f@3:1 bad@2:16" {
+		t.Errorf("wrong trace, got: %v", frames)
+	}
+	if errorObject.Pos.String() != "1:18" {
+		t.Errorf("wrong position, got: %s", errorObject.Pos)
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"map([1, 2, 3], fn(x) { x * 2 })", "[2, 4, 6]"},
+		{"map([], fn(x) { x })", "[]"},
+		{`map(["a", "bc"], len)`, "[1, 2]"},
+		{"filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })", "[2, 4]"},
+		{"reduce([1, 2, 3], fn(acc, x) { acc + x })", "6"},
+		{"reduce([1, 2, 3], fn(acc, x) { push(acc, x * x) }, [])", "[1, 4, 9]"},
+		{"reduce([], fn(acc, x) { acc + x }, 0)", "0"},
+		{"sort([3, 1.5, 2, -1])", "[-1, 1.5, 2, 3]"},
+		{`sort(["b", "c", "a"])`, "[a, b, c]"},
+		{"sort([3, 1, 2], fn(a, b) { a > b })", "[3, 2, 1]"},
+		{"sort([[2, 1], [1, 2], [2, 3], [1, 4]], fn(a, b) { a[0] < b[0] })", "[[1, 2], [1, 4], [2, 1], [2, 3]]"},
+		{"let a = [2, 1]; sort(a); a", "[2, 1]"},
+		{"find([1, 2, 3, 4], fn(x) { x > 2 })", "3"},
+		{"find([1, 2], fn(x) { x > 2 })", "null"},
+		{"any([1, 2, 3], fn(x) { x > 2 })", "true"},
+		{"any([], fn(x) { true })", "false"},
+		{"all([1, 2, 3], fn(x) { x > 0 })", "true"},
+		{"all([1, 2, 3], fn(x) { x > 1 })", "false"},
+		{"all([1, true], fn(x) { x > 1 })", "false"},
+		{`zip([1, 2, 3], ["a", "b"])`, "[[1, a], [2, b]]"},
+		{`zip([1, 2], [3, 4], [5, 6])`, "[[1, 3, 5], [2, 4, 6]]"},
+		{"range(4)", "[0, 1, 2, 3]"},
+		{"range(2, 5)", "[2, 3, 4]"},
+		{"range(5, 0, -2)", "[5, 3, 1]"},
+		{"range(0)", "[]"},
+		{"range(9223372036854775806, 9223372036854775807, 2)", "[9223372036854775806]"},
+		{"range(9223372036854775805, 9223372036854775807, 9223372036854775807)", "[9223372036854775805]"},
+		{"range(-9223372036854775807 - 1, -9223372036854775807 + 2)", "[-9223372036854775808, -9223372036854775807, -9223372036854775806]"},
+		{"range(-9223372036854775807, -9223372036854775807 - 1, -2)", "[-9223372036854775807]"},
+		{"range(9223372036854775807, -9223372036854775807 - 1, -9223372036854775807 - 1)", "[9223372036854775807, -1]"},
+		{"range(-9223372036854775807 - 1, 9223372036854775807, 9223372036854775807)", "[-9223372036854775808, -1, 9223372036854775806]"},
+		{"reverse([1, 2, 3])", "[3, 2, 1]"},
+		{"flatten([[1], [2, [3]], 4, []])", "[1, 2, [3], 4]"},
+		{"reduce(map(filter(range(1, 11), fn(x) { x % 2 == 0 }), fn(x) { x * x }), fn(a, b) { a + b })", "220"},
+		{"len(map(range(100000), fn(x) { x }))", "100000"},
+		{"map([1, 2], fn(x) {})", "[null, null]"},
+		{"filter([1, 2], fn(x) {})", "[]"},
+		{"reduce([1, 2], fn(acc, x) {})", "null"},
+		{"sort([2, 1], fn(a, b) {})", "[2, 1]"},
+
+		{"map([1], 2)", "ERROR: argument 2 to `map` must be FUNCTION or BUILTIN, got INTEGER"},
+		{"map(1, fn(x) { x })", "ERROR: argument 1 to `map` must be ARRAY, got INTEGER"},
+		{"map([1])", "ERROR: builtin map expects 2 arguments, got 1"},
+		{"map([1, 2], fn(x) { x + true })", "ERROR: type missmatch: INTEGER + BOOLEAN"},
+		{"map([1], fn(x, y) { x })", "ERROR: function expects 2 arguments, got 1"},
+		{"filter([1], fn(x) { y })", "ERROR: identifier not found: `y`"},
+		{"reduce([], fn(acc, x) { acc })", "ERROR: `reduce` of an empty ARRAY needs an initial value"},
+		{"reduce([1], fn(acc, x) { acc }, 0, 1)", "ERROR: builtin reduce expects at most 3 arguments, got 4"},
+		{`sort([1, "a"])`, "ERROR: `sort` can not compare STRING with INTEGER"},
+		{"sort([2, 1], fn(a, b) { a + true })", "ERROR: type missmatch: INTEGER + BOOLEAN"},
+		{"sort([1], 1)", "ERROR: argument 2 to `sort` must be FUNCTION or BUILTIN, got INTEGER"},
+		{"zip()", "ERROR: builtin zip expects at least 1 argument, got 0"},
+		{"zip([1], 2)", "ERROR: argument 2 to `zip` must be ARRAY, got INTEGER"},
+		{"range()", "ERROR: builtin range expects 1 to 3 arguments, got 0"},
+		{"range(1, 2, 0)", "ERROR: `range` step can not be 0"},
+		{"range(1000001)", "ERROR: `range` of 1000001 integers exceeds the limit of 1000000"},
+		{"range(-9223372036854775807 - 1, 9223372036854775807)", "ERROR: `range` of 18446744073709551615 integers exceeds the limit of 1000000"},
+		{`range("3")`, "ERROR: argument 1 to `range` must be INTEGER, got STRING"},
+		{"reverse(1)", "ERROR: argument 1 to `reverse` must be ARRAY, got INTEGER"},
+		{"flatten([1], [2])", "ERROR: builtin flatten expects 1 argument, got 2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		got := evaluated.Inspect()
+		// Errors raised in a callback carry a trace, only the message matters
+		if errorObject, ok := evaluated.(*object.Error); ok {
+			got = "ERROR: " + errorObject.Message
+		}
+		if got != tt.expected {
+			t.Errorf("wrong result for %s. Expected: %s, got: %s", tt.input, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package evaluator
+
+import (
+	"monkey/src/ast"
+	"monkey/src/object"
+	"monkey/src/token"
+)
+
+/*
+  Monkey has no loops, recursion is the only way to iterate. To keep a
+  recursive loop from growing the Go stack, a call in tail position is not
+  applied where it is evaluated: it is returned as a *tailCall and the
+  trampoline of the enclosing call applies it once the current function
+  body is done.
+
+  Tail positions are the last statement of a function body, both branches
+  of an `if` in tail position and the value of a `return` statement of the
+  body, reached through blocks and `if` statements only. A `return` nested
+  in an expression, like an `if` used as a value, does not leave the function
+  right away: the expression consumes its value, so its calls are applied.
+*/
+
+// A call waiting to be applied by trampoline, it never escapes the evaluator
+type tailCall struct {
+	node *ast.CallExpression // nil for calls made by the host through Apply
+	fn   object.Object
+	args []object.Object
+	env  *object.Environment // The environment of the call site
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+func (tc *tailCall) pos() token.Position {
+	if tc.node == nil {
+		return token.Position{}
+	}
+	return tc.node.Pos()
+}
+
+func (tc *tailCall) end() token.Position {
+	if tc.node == nil {
+		return token.Position{}
+	}
+	return tc.node.End()
+}
+
+// evalTail evaluates node, a statement of a function body or a part of one.
+// When tail is true, node is in tail position and calls are returned as
+// *tailCall instead of being applied. The value of a `return` always is
+func evalTail(node ast.Node, env *object.Environment, tail bool) object.Object {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		var result object.Object
+		for i, statement := range node.Statements {
+			if i == len(node.Statements)-1 {
+				return evalTail(statement, env, tail)
+			}
+
+			result = evalTail(statement, env, false)
+			if result != nil {
+				rt := result.Type()
+				if rt == object.RETURN_OBJ || rt == object.ERROR_OBJ {
+					return result
+				}
+			}
+		}
+		return result
+	case *ast.ExpressionStatement:
+		return evalTail(node.Expression, env, tail)
+	case *ast.ReturnStatement:
+		val := evalTail(node.ReturnValue, env, true)
+		if isError(val) {
+			return val
+		}
+		return &object.ReturnValue{Value: val}
+	case *ast.IfExpression:
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+
+		if isTruthy(condition) {
+			return evalTail(node.Consequence, env, tail)
+		} else if node.Alternative != nil {
+			return evalTail(node.Alternative, env, tail)
+		} else {
+			return NULL
+		}
+	case *ast.CallExpression:
+		if !tail {
+			return Eval(node, env)
+		}
+
+		function := Eval(node.Function, env)
+		if isError(function) {
+			return function
+		}
+
+		args := evalExpression(node.Arguments, env)
+		if len(args) == 1 && isError(args[0]) {
+			return args[0]
+		}
+		return &tailCall{node: node, fn: function, args: args, env: env}
+	default:
+		return Eval(node, env)
+	}
+}
+
+// trampoline applies result if it is a *tailCall, then every tail call the
+// function returns in turn. They all share a single frame of the call stack,
+// each tail call replacing the previous one
+func trampoline(result object.Object) object.Object {
+	tc, ok := result.(*tailCall)
+	if !ok {
+		return result
+	}
+
+	rt := tc.env.Runtime()
+	base := len(rt.Frames)
+	defer func() { rt.Frames = rt.Frames[:base] }()
+
+	for {
+		if fn, ok := tc.fn.(*object.Function); ok {
+			frame := object.Frame{Function: fn.Name, Pos: tc.pos()}
+			if len(rt.Frames) > base {
+				rt.Frames[base] = frame
+			} else if base >= rt.MaxDepth() {
+				err := newError("maximum recursion depth exceeded: %d calls deep in %s", base, functionName(fn))
+				atCallSite(err, tc, rt.Frames[:base])
+				return err
+			} else {
+				rt.Frames = append(rt.Frames, frame)
+			}
+		}
+
+		result = applyFunction(tc.fn, tc.args, tc.env, tc.node)
+
+		// An error without a position is about the call itself (wrong
+		// arguments, not a function, a failing builtin) so it belongs to the
+		// caller, errors from the body of the function are already located
+		if err, ok := result.(*object.Error); ok && !err.Pos.IsValid() {
+			atCallSite(err, tc, rt.Frames[:base])
+		}
+
+		next, ok := result.(*tailCall)
+		if !ok {
+			return result
+		}
+		tc = next
+	}
+}
+
+// atCallSite locates err at the call tc, made from within frames
+func atCallSite(err *object.Error, tc *tailCall, frames []object.Frame) {
+	err.Pos, err.End = tc.pos(), tc.end()
+	err.Trace = append([]object.Frame{}, frames...)
+}
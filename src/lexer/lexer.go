@@ -1,45 +1,116 @@
 package lexer
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"monkey/src/token"
 )
 
+// The lexer reads the input one rune at a time, positions are still counted
+// in bytes
 type Lexer struct {
+	filename     string
 	input        string
 	position     int
 	readPosition int
-	ch           byte
+	ch           rune
+
+	// line of the current character and the offset that line starts at
+	line      int
+	lineStart int
+
+	errors []*Error
+
+	// Comments are skipped by NextToken and kept here instead
+	comments []token.Token
+}
+
+// A lexical error, the lexer emits an ILLEGAL token where it happened
+type Error struct {
+	Pos     token.Position
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Pos.String() + ": " + e.Message
 }
 
 func New(input string) *Lexer {
+	return NewFile("", input)
+}
+
+// NewFile creates a lexer whose token positions carry the given file name
+func NewFile(filename, input string) *Lexer {
 	l := &Lexer{
-		input: input,
+		filename: filename,
+		input:    input,
+		line:     1,
 	}
 	l.readChar()
 	return l
 }
 
-func (l *Lexer) peekChar() byte {
+// Errors returns the lexical errors found so far, in source order
+func (l *Lexer) Errors() []*Error {
+	return l.errors
+}
+
+// Comments returns the comments skipped so far as COMMENT tokens, in source
+// order. The literal is the whole comment, including the // or /* */
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
+func (l *Lexer) error(pos token.Position, format string, a ...interface{}) {
+	l.errors = append(l.errors, &Error{Pos: pos, Message: fmt.Sprintf(format, a...)})
+}
+
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.readPosition]
 	}
+	ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	return ch
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.lineStart = l.readPosition
+	}
+	l.position = l.readPosition
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
-	} else {
-		l.ch = l.input[l.readPosition]
+		l.readPosition = len(l.input)
+		return
+	}
+
+	ch, width := utf8.DecodeRuneInString(l.input[l.readPosition:])
+	if ch == utf8.RuneError && width == 1 {
+		l.error(l.pos(), "invalid UTF-8 encoding")
+	}
+	l.ch = ch
+	l.readPosition += width
+}
+
+// pos returns the position of the current character
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.position - l.lineStart + 1,
 	}
-	l.position = l.readPosition
-	l.readPosition++
 }
 
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
-	l.skipWhiteSpace()
+	l.skipTrivia()
+	pos := l.pos()
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -76,16 +147,68 @@ func (l *Lexer) NextToken() token.Token {
 	case '-':
 		tok = newToken(token.MINUS, l.ch)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			l.readChar()
+			tok = token.Token{Type: token.POWER, Literal: "**"}
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '/':
 		tok = newToken(token.SLASH, l.ch)
 	case '<':
-		tok = newToken(token.LT, l.ch)
+		if l.peekChar() == '<' {
+			l.readChar()
+			tok = token.Token{Type: token.SHIFT_LEFT, Literal: "<<"}
+		} else if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.LT_EQ, Literal: "<="}
+		} else {
+			tok = newToken(token.LT, l.ch)
+		}
 	case '>':
-		tok = newToken(token.GT, l.ch)
+		if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.SHIFT_RIGHT, Literal: ">>"}
+		} else if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.GT_EQ, Literal: ">="}
+		} else {
+			tok = newToken(token.GT, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			l.readChar()
+			tok = token.Token{Type: token.AND, Literal: "&&"}
+		} else {
+			tok = newToken(token.AMPERSAND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok = token.Token{Type: token.OR, Literal: "||"}
+		} else {
+			tok = newToken(token.PIPE, l.ch)
+		}
+	case '^':
+		tok = newToken(token.CARET, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
 	case '"':
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
+		if l.ch == 0 {
+			l.error(pos, "unterminated string literal")
+			tok.Type = token.ILLEGAL
+		}
+	case '`':
+		tok.Type = token.STRING
+		tok.Literal = l.readRawString()
+		if l.ch == 0 {
+			l.error(pos, "unterminated raw string literal")
+			tok.Type = token.ILLEGAL
+		}
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
 	case ']':
@@ -95,67 +218,321 @@ func (l *Lexer) NextToken() token.Token {
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
+		tok.Pos = pos
+		tok.End = pos
+		return tok
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookUpIdent(tok.Literal)
+			tok.Pos = pos
+			tok.End = l.pos()
 			return tok
 		} else if isDigit(l.ch) {
-			tok.Literal = l.readNumber()
-			tok.Type = token.INT
+			tok.Literal, tok.Type = l.readNumber()
+			tok.Pos = pos
+			tok.End = l.pos()
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			// invalid UTF-8 was already reported by readChar
+			if l.ch != utf8.RuneError || l.readPosition-l.position > 1 {
+				l.error(pos, "illegal character %#U", l.ch)
+			}
+			tok = token.Token{Type: token.ILLEGAL, Literal: l.input[l.position:l.readPosition]}
 		}
 	}
 	l.readChar()
+	tok.Pos = pos
+	tok.End = l.pos()
 	return tok
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{
 		Type:    tokenType,
 		Literal: string(ch),
 	}
 }
 
+// readString reads a "..." string and returns its value with the escape
+// sequences decoded. It stops on the closing quote, or at EOF when there is
+// none
 func (l *Lexer) readString() string {
+	var out strings.Builder
+	for {
+		l.readChar()
+		switch l.ch {
+		case '"', 0:
+			return out.String()
+		case '\\':
+			l.readEscape(&out)
+		default:
+			// Write the bytes as they are, so invalid UTF-8 is kept
+			out.WriteString(l.input[l.position:l.readPosition])
+		}
+	}
+}
+
+// readEscape decodes the escape sequence starting at the current backslash
+func (l *Lexer) readEscape(out *strings.Builder) {
+	pos := l.pos()
+	l.readChar()
+
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '\\':
+		out.WriteByte('\\')
+	case '"':
+		out.WriteByte('"')
+	case 'u':
+		l.readUnicodeEscape(pos, out)
+	case 0:
+		// unterminated, reported by the caller
+	default:
+		l.error(pos, "unknown escape sequence \\%c", l.ch)
+	}
+}
+
+// readUnicodeEscape decodes the `{...}` part of a \u{...} escape, holding the
+// hexadecimal code point of the character
+func (l *Lexer) readUnicodeEscape(pos token.Position, out *strings.Builder) {
+	if l.peekChar() != '{' {
+		l.error(pos, "invalid unicode escape, expected \\u{...}")
+		return
+	}
+	l.readChar()
+
+	start := l.readPosition
+	for l.peekChar() != '}' && l.peekChar() != '"' && l.peekChar() != 0 {
+		l.readChar()
+	}
+	digits := l.input[start:l.readPosition]
+	if l.peekChar() != '}' {
+		l.error(pos, "invalid unicode escape, missing closing }")
+		return
+	}
+	l.readChar()
+
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(code)) {
+		l.error(pos, "invalid unicode escape \\u{%s}", digits)
+		return
+	}
+	out.WriteRune(rune(code))
+}
+
+// readRawString reads a `...` string, which may span several lines and has
+// no escape sequences
+func (l *Lexer) readRawString() string {
 	position := l.position + 1
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
+		if l.ch == '`' || l.ch == 0 {
 			break
 		}
 	}
 	return l.input[position:l.position]
 }
 
+// Identifiers follow the go spec: a letter followed by letters and digits,
+// where `_` counts as a letter and both may be any unicode letter or digit
 func (l *Lexer) readIdentifier() string {
 	pos := l.position
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || unicode.IsDigit(l.ch) {
 		l.readChar()
 	}
 	return l.input[pos:l.position]
 }
 
-func (l *Lexer) readNumber() string {
-	pos := l.position
-	for isDigit(l.ch) {
+// readNumber reads an integer, or a float when there is a fraction or an
+// exponent: 3.14, 1e-9, 2.5E3. Integers may have a base prefix (0x, 0o, 0b)
+// and any number may use `_` between digits: 1_000_000, 0b1010_0101
+func (l *Lexer) readNumber() (string, token.TokenType) {
+	pos := l.pos()
+	tokenType := token.TokenType(token.INT)
+
+	if l.ch == '0' && strings.ContainsRune("xXoObB", l.peekChar()) {
 		l.readChar()
+		l.readChar()
+		l.readPrefixedDigits(pos)
+		return l.input[pos.Offset:l.position], tokenType
 	}
-	return l.input[pos:l.position]
+
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		tokenType = token.FLOAT
+		exponent := l.pos()
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		if !isDigit(l.ch) {
+			l.error(exponent, "exponent has no digits")
+		}
+		l.readDigits()
+	}
+
+	literal := l.input[pos.Offset:l.position]
+	if tokenType == token.INT && len(literal) > 1 && literal[0] == '0' {
+		// A leading 0 is the old octal notation, as in go: 0755
+		if !l.checkDigits(pos, literal, 1, 8, "octal") {
+			return literal, tokenType
+		}
+	}
+	l.checkSeparators(pos, literal, 10)
+
+	return literal, tokenType
+}
+
+// readPrefixedDigits reads the digits after a 0x, 0o or 0b prefix. Letters
+// and digits right after are read too so that 0b102 is a single bad literal
+// instead of 0b10 followed by 2
+func (l *Lexer) readPrefixedDigits(pos token.Position) {
+	for isLetter(l.ch) || isDigit(l.ch) {
+		l.readChar()
+	}
+
+	literal := l.input[pos.Offset:l.position]
+	base, name := 16, "hexadecimal"
+	switch literal[1] {
+	case 'o', 'O':
+		base, name = 8, "octal"
+	case 'b', 'B':
+		base, name = 2, "binary"
+	}
+
+	if strings.Trim(literal[2:], "_") == "" {
+		l.error(pos, "%s literal has no digits", name)
+		return
+	}
+	if l.checkDigits(pos, literal, 2, base, name) {
+		l.checkSeparators(pos, literal, base)
+	}
+}
+
+// checkDigits reports the first character of literal[from:] that is not a
+// digit of the given base, returns false when there was one
+func (l *Lexer) checkDigits(pos token.Position, literal string, from, base int, name string) bool {
+	for i := from; i < len(literal); i++ {
+		ch := literal[i]
+		if ch != '_' && digitValue(ch) >= base {
+			l.error(pos.Advance(i), "invalid digit %q in %s literal", ch, name)
+			return false
+		}
+	}
+	return true
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// checkSeparators reports a `_` that does not sit between two digits, the
+// base prefix counts as a digit so 0x_FF is fine
+func (l *Lexer) checkSeparators(pos token.Position, literal string, base int) {
+	isPrefix := func(i int) bool {
+		return i == 1 && literal[0] == '0' && strings.ContainsRune("xXoObB", rune(literal[1]))
+	}
+
+	for i := 0; i < len(literal); i++ {
+		if literal[i] != '_' {
+			continue
+		}
+		before := i > 0 && (digitValue(literal[i-1]) < base || isPrefix(i-1))
+		after := i+1 < len(literal) && digitValue(literal[i+1]) < base
+		if !before || !after {
+			l.error(pos.Advance(i), "'_' must separate successive digits")
+			return
+		}
+	}
 }
 
-func isDigit(ch byte) bool {
+// digitValue returns the value of a hexadecimal digit, 16 for anything else
+func digitValue(ch byte) int {
+	switch {
+	case '0' <= ch && ch <= '9':
+		return int(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		return int(ch - 'a' + 10)
+	case 'A' <= ch && ch <= 'F':
+		return int(ch - 'A' + 10)
+	default:
+		return 16
+	}
+}
+
+// readDigits reads decimal digits and `_` separators
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
+		l.readChar()
+	}
+}
+
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
+}
+
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) skipWhiteSpace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+// skipTrivia skips white spaces and comments
+func (l *Lexer) skipTrivia() {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/':
+			l.readLineComment()
+		case l.ch == '/' && l.peekChar() == '*':
+			l.readBlockComment()
+		default:
+			return
+		}
+	}
+}
+
+// readLineComment reads a // comment up to the end of the line
+func (l *Lexer) readLineComment() {
+	pos := l.pos()
+	for l.ch != '\n' && l.ch != 0 {
+		l.readChar()
+	}
+	l.addComment(pos)
+}
+
+// readBlockComment reads a /* */ comment, they do not nest
+func (l *Lexer) readBlockComment() {
+	pos := l.pos()
+	l.readChar()
+	l.readChar()
+	for !(l.ch == '*' && l.peekChar() == '/') {
+		if l.ch == 0 {
+			l.error(pos, "unterminated block comment")
+			l.addComment(pos)
+			return
+		}
 		l.readChar()
 	}
+	l.readChar()
+	l.readChar()
+	l.addComment(pos)
+}
+
+func (l *Lexer) addComment(pos token.Position) {
+	l.comments = append(l.comments, token.Token{
+		Type:    token.COMMENT,
+		Literal: l.input[pos.Offset:l.position],
+		Pos:     pos,
+		End:     l.pos(),
+	})
 }
@@ -15,7 +15,7 @@ func TestNextToken(t *testing.T) {
     };
     
     let result = add(five, ten);
-    !-/*5;
+    !-/ *5;
     5 < 10 > 5;
     
     if (5 < 10) {
@@ -142,3 +142,397 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  add(x,\n\t\"hi\");"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+		expectedEnd  token.Position
+	}{
+		{token.LET, token.Position{Filename: "test.mk", Offset: 0, Line: 1, Column: 1}, token.Position{Filename: "test.mk", Offset: 3, Line: 1, Column: 4}},
+		{token.IDENT, token.Position{Filename: "test.mk", Offset: 4, Line: 1, Column: 5}, token.Position{Filename: "test.mk", Offset: 5, Line: 1, Column: 6}},
+		{token.ASSIGN, token.Position{Filename: "test.mk", Offset: 6, Line: 1, Column: 7}, token.Position{Filename: "test.mk", Offset: 7, Line: 1, Column: 8}},
+		{token.INT, token.Position{Filename: "test.mk", Offset: 8, Line: 1, Column: 9}, token.Position{Filename: "test.mk", Offset: 9, Line: 1, Column: 10}},
+		{token.SEMICOLON, token.Position{Filename: "test.mk", Offset: 9, Line: 1, Column: 10}, token.Position{Filename: "test.mk", Offset: 10, Line: 1, Column: 11}},
+		{token.IDENT, token.Position{Filename: "test.mk", Offset: 13, Line: 2, Column: 3}, token.Position{Filename: "test.mk", Offset: 16, Line: 2, Column: 6}},
+		{token.LPAREN, token.Position{Filename: "test.mk", Offset: 16, Line: 2, Column: 6}, token.Position{Filename: "test.mk", Offset: 17, Line: 2, Column: 7}},
+		{token.IDENT, token.Position{Filename: "test.mk", Offset: 17, Line: 2, Column: 7}, token.Position{Filename: "test.mk", Offset: 18, Line: 2, Column: 8}},
+		{token.COMMA, token.Position{Filename: "test.mk", Offset: 18, Line: 2, Column: 8}, token.Position{Filename: "test.mk", Offset: 19, Line: 2, Column: 9}},
+		{token.STRING, token.Position{Filename: "test.mk", Offset: 21, Line: 3, Column: 2}, token.Position{Filename: "test.mk", Offset: 25, Line: 3, Column: 6}},
+		{token.RPAREN, token.Position{Filename: "test.mk", Offset: 25, Line: 3, Column: 6}, token.Position{Filename: "test.mk", Offset: 26, Line: 3, Column: 7}},
+		{token.SEMICOLON, token.Position{Filename: "test.mk", Offset: 26, Line: 3, Column: 7}, token.Position{Filename: "test.mk", Offset: 27, Line: 3, Column: 8}},
+		{token.EOF, token.Position{Filename: "test.mk", Offset: 27, Line: 3, Column: 8}, token.Position{Filename: "test.mk", Offset: 27, Line: 3, Column: 8}},
+	}
+
+	lexer := NewFile("test.mk", input)
+
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("Test [%d] type failed. Expected: %q, got: %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("Test [%d] pos failed. Expected: %+v, got: %+v", i, tt.expectedPos, tok.Pos)
+		}
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("Test [%d] end failed. Expected: %+v, got: %+v", i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestUnicodeInput(t *testing.T) {
+	input := "let café = \"日本語\"; π2 + _x1 ü"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumn  int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENT, "café", 5},
+		{token.ASSIGN, "=", 11},
+		{token.STRING, "日本語", 13},
+		{token.SEMICOLON, ";", 24},
+		{token.IDENT, "π2", 26},
+		{token.PLUS, "+", 30},
+		{token.IDENT, "_x1", 32},
+		{token.IDENT, "ü", 36},
+		{token.EOF, "", 38},
+	}
+
+	lexer := New(input)
+
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("Test [%d] type failed. Expected: %q, got: %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("Test [%d] literal failed. Expected: %q, got: %q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos.Column != tt.expectedColumn {
+			t.Fatalf("Test [%d] column failed. Expected: %d, got: %d", i, tt.expectedColumn, tok.Pos.Column)
+		}
+	}
+
+	if len(lexer.Errors()) != 0 {
+		t.Fatalf("unexpected lexer errors: %v", lexer.Errors())
+	}
+}
+
+func TestLexicalErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedError   string
+	}{
+		{"@", token.ILLEGAL, "@", "1:1: illegal character U+0040 '@'"},
+		{"\n  \xff", token.ILLEGAL, "\xff", "2:3: invalid UTF-8 encoding"},
+		{"\"a\xfeb\"", token.STRING, "a\xfeb", "1:3: invalid UTF-8 encoding"},
+		{"€", token.ILLEGAL, "€", "1:1: illegal character U+20AC '€'"},
+	}
+
+	for _, tt := range tests {
+		lexer := New(tt.input)
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("input %q: type failed. Expected: %q, got: %q", tt.input, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("input %q: literal failed. Expected: %q, got: %q", tt.input, tt.expectedLiteral, tok.Literal)
+		}
+		if len(lexer.Errors()) != 1 {
+			t.Errorf("input %q: expected 1 error, got %d", tt.input, len(lexer.Errors()))
+			continue
+		}
+		if lexer.Errors()[0].Error() != tt.expectedError {
+			t.Errorf("input %q: wrong error. Expected: %q, got: %q", tt.input, tt.expectedError, lexer.Errors()[0].Error())
+		}
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedErrors  []string
+	}{
+		{`"a\nb\tc"`, token.STRING, "a\nb\tc", nil},
+		{`"say \"hi\" \\ bye"`, token.STRING, `say "hi" \ bye`, nil},
+		{`"\u{48}\u{e9}\u{1F600}"`, token.STRING, "Hé😀", nil},
+		{"`raw \\n \"quoted\"\nsecond line`", token.STRING, "raw \\n \"quoted\"\nsecond line", nil},
+		{`"bad \q escape"`, token.STRING, "bad  escape", []string{`1:6: unknown escape sequence \q`}},
+		{`"\u{110000}"`, token.STRING, "", []string{`1:2: invalid unicode escape \u{110000}`}},
+		{`"\u41"`, token.STRING, "41", []string{`1:2: invalid unicode escape, expected \u{...}`}},
+		{`"\u{41"`, token.STRING, "", []string{`1:2: invalid unicode escape, missing closing }`}},
+		{`"never closed`, token.ILLEGAL, "never closed", []string{"1:1: unterminated string literal"}},
+		{"\n  `never closed", token.ILLEGAL, "never closed", []string{"2:3: unterminated raw string literal"}},
+	}
+
+	for _, tt := range tests {
+		lexer := New(tt.input)
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("input %q: type failed. Expected: %q, got: %q", tt.input, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("input %q: literal failed. Expected: %q, got: %q", tt.input, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.End.Offset != len(tt.input) {
+			t.Errorf("input %q: token does not end at the end of input, got offset %d", tt.input, tok.End.Offset)
+		}
+
+		errors := lexer.Errors()
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("input %q: expected %d errors, got %v", tt.input, len(tt.expectedErrors), errors)
+			continue
+		}
+		for i, expected := range tt.expectedErrors {
+			if errors[i].Error() != expected {
+				t.Errorf("input %q: wrong error. Expected: %q, got: %q", tt.input, expected, errors[i].Error())
+			}
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// line comment
+let x = 1; /* block
+comment */ x / 2 // trailing
+/// doc
+/* never closed`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.EOF, ""},
+	}
+
+	lexer := New(input)
+
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("Test [%d] type failed. Expected: %q, got: %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("Test [%d] literal failed. Expected: %q, got: %q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	expectedComments := []struct {
+		literal string
+		pos     string
+		end     string
+	}{
+		{"// line comment", "1:1", "1:16"},
+		{"/* block\ncomment */", "2:12", "3:11"},
+		{"// trailing", "3:18", "3:29"},
+		{"/// doc", "4:1", "4:8"},
+		{"/* never closed", "5:1", "5:16"},
+	}
+
+	comments := lexer.Comments()
+	if len(comments) != len(expectedComments) {
+		t.Fatalf("expected %d comments, got %d", len(expectedComments), len(comments))
+	}
+	for i, expected := range expectedComments {
+		c := comments[i]
+		if c.Type != token.COMMENT || c.Literal != expected.literal {
+			t.Errorf("comments[%d] wrong. Expected: %q, got: %s %q", i, expected.literal, c.Type, c.Literal)
+		}
+		if c.Pos.String() != expected.pos || c.End.String() != expected.end {
+			t.Errorf("comments[%d] span wrong. Expected: %s-%s, got: %s-%s", i, expected.pos, expected.end, c.Pos, c.End)
+		}
+	}
+
+	if len(lexer.Errors()) != 1 || lexer.Errors()[0].Error() != "5:1: unterminated block comment" {
+		t.Errorf("expected unterminated block comment error, got %v", lexer.Errors())
+	}
+}
+
+func TestNumberLiterals(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedError   string
+	}{
+		{"42", token.INT, "42", ""},
+		{"3.14", token.FLOAT, "3.14", ""},
+		{"1e-9", token.FLOAT, "1e-9", ""},
+		{"2.5E+3", token.FLOAT, "2.5E+3", ""},
+		{"6e2", token.FLOAT, "6e2", ""},
+		{"1e", token.FLOAT, "1e", "1:2: exponent has no digits"},
+		{"1.", token.INT, "1", ""},
+	}
+
+	for _, tt := range tests {
+		lexer := New(tt.input)
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("input %q: type failed. Expected: %q, got: %q", tt.input, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("input %q: literal failed. Expected: %q, got: %q", tt.input, tt.expectedLiteral, tok.Literal)
+		}
+
+		errors := lexer.Errors()
+		switch {
+		case tt.expectedError == "" && len(errors) != 0:
+			t.Errorf("input %q: unexpected errors %v", tt.input, errors)
+		case tt.expectedError != "" && (len(errors) != 1 || errors[0].Error() != tt.expectedError):
+			t.Errorf("input %q: expected error %q, got %v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
+
+func TestIntegerLiteralBases(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedError   string
+	}{
+		{"0xFF", token.INT, "0xFF", ""},
+		{"0Xdead_beef", token.INT, "0Xdead_beef", ""},
+		{"0o755", token.INT, "0o755", ""},
+		{"0b1010", token.INT, "0b1010", ""},
+		{"0b_1010_0101", token.INT, "0b_1010_0101", ""},
+		{"1_000_000", token.INT, "1_000_000", ""},
+		{"0755", token.INT, "0755", ""},
+		{"1_000.000_1", token.FLOAT, "1_000.000_1", ""},
+		{"0b102", token.INT, "0b102", "1:5: invalid digit '2' in binary literal"},
+		{"0o78", token.INT, "0o78", "1:4: invalid digit '8' in octal literal"},
+		{"0xFG", token.INT, "0xFG", "1:4: invalid digit 'G' in hexadecimal literal"},
+		{"089", token.INT, "089", "1:2: invalid digit '8' in octal literal"},
+		{"0x", token.INT, "0x", "1:1: hexadecimal literal has no digits"},
+		{"0b__", token.INT, "0b__", "1:1: binary literal has no digits"},
+		{"1__000", token.INT, "1__000", "1:2: '_' must separate successive digits"},
+		{"1000_", token.INT, "1000_", "1:5: '_' must separate successive digits"},
+		{"1_.5", token.FLOAT, "1_.5", "1:2: '_' must separate successive digits"},
+		{"1.5_e3", token.FLOAT, "1.5_e3", "1:4: '_' must separate successive digits"},
+	}
+
+	for _, tt := range tests {
+		lexer := New(tt.input)
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Errorf("input %q: type failed. Expected: %q, got: %q", tt.input, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("input %q: literal failed. Expected: %q, got: %q", tt.input, tt.expectedLiteral, tok.Literal)
+		}
+
+		errors := lexer.Errors()
+		switch {
+		case tt.expectedError == "" && len(errors) != 0:
+			t.Errorf("input %q: unexpected errors %v", tt.input, errors)
+		case tt.expectedError != "" && (len(errors) != 1 || errors[0].Error() != tt.expectedError):
+			t.Errorf("input %q: expected error %q, got %v", tt.input, tt.expectedError, errors)
+		}
+	}
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	input := "a & b | c ^ ~d << 2 >> 1 < 3 > 4 && e || f"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.AMPERSAND, "&"},
+		{token.IDENT, "b"},
+		{token.PIPE, "|"},
+		{token.IDENT, "c"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.IDENT, "d"},
+		{token.SHIFT_LEFT, "<<"},
+		{token.INT, "2"},
+		{token.SHIFT_RIGHT, ">>"},
+		{token.INT, "1"},
+		{token.LT, "<"},
+		{token.INT, "3"},
+		{token.GT, ">"},
+		{token.INT, "4"},
+		{token.AND, "&&"},
+		{token.IDENT, "e"},
+		{token.OR, "||"},
+		{token.IDENT, "f"},
+		{token.EOF, ""},
+	}
+
+	lexer := New(input)
+
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("Test [%d] type failed. Expected: %q, got: %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("Test [%d] literal failed. Expected: %q, got: %q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestComparisonAndArithmeticOperators(t *testing.T) {
+	input := "a <= b >= c % d ** e * f < g << h"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.LT_EQ, "<="},
+		{token.IDENT, "b"},
+		{token.GT_EQ, ">="},
+		{token.IDENT, "c"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "d"},
+		{token.POWER, "**"},
+		{token.IDENT, "e"},
+		{token.ASTERISK, "*"},
+		{token.IDENT, "f"},
+		{token.LT, "<"},
+		{token.IDENT, "g"},
+		{token.SHIFT_LEFT, "<<"},
+		{token.IDENT, "h"},
+		{token.EOF, ""},
+	}
+
+	lexer := New(input)
+
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("Test [%d] type failed. Expected: %q, got: %q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("Test [%d] literal failed. Expected: %q, got: %q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
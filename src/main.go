@@ -2,12 +2,22 @@ package main
 
 import (
 	"fmt"
-	"monkey/src/repl"
 	"os"
 	"os/user"
+
+	"monkey/src/diagnostics"
+	"monkey/src/evaluator"
+	"monkey/src/lexer"
+	"monkey/src/object"
+	"monkey/src/parser"
+	"monkey/src/repl"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runFile(os.Args[1]))
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -15,3 +25,42 @@ func main() {
 	fmt.Printf("Hello: %s\n", user.Username)
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+// runFile evaluates a whole script, reporting errors on stderr, and returns
+// the process exit code
+func runFile(path string) int {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	opts := diagnostics.Options{Color: isTerminal(os.Stderr)}
+
+	l := lexer.NewFile(path, string(src))
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.ParseErrors()) != 0 {
+		for _, err := range p.ParseErrors() {
+			diagnostics.Render(os.Stderr, string(src), diagnostics.FromParseError(err), opts)
+		}
+		return 1
+	}
+
+	evaluated := evaluator.SafeEval(program, object.NewEnvironment())
+	if err, ok := evaluated.(*object.Error); ok {
+		diagnostics.Render(os.Stderr, string(src), diagnostics.FromError(err), opts)
+		return 1
+	}
+
+	return 0
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,714 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+/*
+  FromGo and ToGo bridge Go values and Monkey objects:
+
+    Go                              Monkey
+    bool                            BOOLEAN
+    int*, uint*                     INTEGER
+    float32, float64                FLOAT
+    string                          STRING
+    slice, array                    ARRAY
+    map, struct                     HASH
+    func                            BUILTIN
+    nil pointer, slice, map, ...    NULL
+
+  Struct fields are hash keys named after the field, or after its `monkey`
+  tag: `monkey:"name"` renames the field and `monkey:"-"` leaves it out.
+  Unexported fields are left out.
+*/
+
+var (
+	objectType      = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+	callContextType = reflect.TypeOf((*CallContext)(nil)).Elem()
+)
+
+// FromGo converts v to an object, v may already be an Object
+func FromGo(v any) (Object, error) {
+	if v == nil {
+		return NULL, nil
+	}
+	return fromGo(reflect.ValueOf(v), "", nil)
+}
+
+// A pointer, map or slice being converted, seen again while converting what
+// it holds when the Go value is cyclic
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// visiting holds the values being converted on the path to v, it is
+// allocated by the first one met
+func fromGo(v reflect.Value, path string, visiting map[visit]bool) (Object, error) {
+	if v.Type().Implements(objectType) && v.Kind() != reflect.Interface {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return NULL, nil
+		}
+		return v.Interface().(Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+		key := visit{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			key.len = v.Len()
+		}
+		if visiting[key] {
+			return nil, conversionError(path, "unsupported cyclic value: %s", v.Type())
+		}
+		if visiting == nil {
+			visiting = map[visit]bool{}
+		}
+		visiting[key] = true
+		defer delete(visiting, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return TRUE, nil
+		}
+		return FALSE, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > 1<<63-1 {
+			return nil, conversionError(path, "%d overflows INTEGER", v.Uint())
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromGo(v.Elem(), path, visiting)
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+		elements := make([]Object, v.Len())
+		for i := range elements {
+			element, err := fromGo(v.Index(i), fmt.Sprintf("%s[%d]", path, i), visiting)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &Array{Elements: elements}, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		hash := &Hash{Pairs: map[HashKey]HashPair{}}
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromGo(iter.Key(), path, visiting)
+			if err != nil {
+				return nil, err
+			}
+			value, err := fromGo(iter.Value(), fmt.Sprintf("%s[%s]", path, keyString(iter.Key())), visiting)
+			if err != nil {
+				return nil, err
+			}
+			if err := hash.set(key, value); err != nil {
+				return nil, conversionError(path, "%s", err)
+			}
+		}
+		return hash, nil
+	case reflect.Struct:
+		hash := &Hash{Pairs: map[HashKey]HashPair{}}
+		for _, field := range structFields(v.Type()) {
+			// Left out when promoted from a nil embedded pointer
+			fieldValue, err := v.FieldByIndexErr(field.index)
+			if err != nil {
+				continue
+			}
+			value, err := fromGo(fieldValue, path+"."+field.name, visiting)
+			if err != nil {
+				return nil, err
+			}
+			hash.set(&String{Value: field.name}, value)
+		}
+		return hash, nil
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		builtin, err := newBuiltin("", v)
+		if err != nil {
+			return nil, conversionError(path, "%s", err)
+		}
+		return builtin, nil
+	default:
+		return nil, conversionError(path, "unsupported Go type: %s", v.Type())
+	}
+}
+
+// ToGo stores obj in the Go value target points to, converting it to the
+// type of that value. Stored in an `any`, integers are int64, floats are
+// float64, arrays are []any and hashes are map[string]any, or map[any]any
+// when some key is not a string. Builtins taking a CallContext do not convert
+// to Go functions, they can only be called by the evaluator
+func ToGo(obj Object, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, got: %T", target)
+	}
+	return toGo(obj, v.Elem(), "")
+}
+
+func toGo(obj Object, v reflect.Value, path string) error {
+	// Empty blocks evaluate to nil
+	if obj == nil {
+		obj = NULL
+	}
+
+	objValue := reflect.ValueOf(obj)
+	if v.Kind() != reflect.Interface || v.NumMethod() > 0 {
+		if objValue.Type().AssignableTo(v.Type()) {
+			v.Set(objValue)
+			return nil
+		}
+		if v.Type().Implements(objectType) {
+			return &typeMismatch{path: path, got: obj.Type(), want: v.Type()}
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.NumMethod() > 0 {
+			break
+		}
+		natural, err := naturalGo(obj, path)
+		if err != nil {
+			return err
+		}
+		if natural == nil {
+			v.SetZero()
+		} else {
+			v.Set(reflect.ValueOf(natural))
+		}
+		return nil
+	case reflect.Bool:
+		if obj, ok := obj.(*Boolean); ok {
+			v.SetBool(obj.Value)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if obj, ok := obj.(*Integer); ok {
+			if v.OverflowInt(obj.Value) {
+				return conversionError(path, "%d overflows %s", obj.Value, v.Type())
+			}
+			v.SetInt(obj.Value)
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if obj, ok := obj.(*Integer); ok {
+			if obj.Value < 0 || v.OverflowUint(uint64(obj.Value)) {
+				return conversionError(path, "%d overflows %s", obj.Value, v.Type())
+			}
+			v.SetUint(uint64(obj.Value))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch obj := obj.(type) {
+		case *Float:
+			v.SetFloat(obj.Value)
+			return nil
+		case *Integer:
+			v.SetFloat(float64(obj.Value))
+			return nil
+		}
+	case reflect.String:
+		if obj, ok := obj.(*String); ok {
+			v.SetString(obj.Value)
+			return nil
+		}
+	case reflect.Pointer:
+		if obj == NULL {
+			v.SetZero()
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := toGo(obj, elem.Elem(), path); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Slice:
+		if obj == NULL {
+			v.SetZero()
+			return nil
+		}
+		if obj, ok := obj.(*Array); ok {
+			slice := reflect.MakeSlice(v.Type(), len(obj.Elements), len(obj.Elements))
+			for i, element := range obj.Elements {
+				if err := toGo(element, slice.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			v.Set(slice)
+			return nil
+		}
+	case reflect.Array:
+		if obj, ok := obj.(*Array); ok {
+			if len(obj.Elements) != v.Len() {
+				return conversionError(path, "cannot convert ARRAY of %d elements to %s", len(obj.Elements), v.Type())
+			}
+			for i, element := range obj.Elements {
+				if err := toGo(element, v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Map:
+		if obj == NULL {
+			v.SetZero()
+			return nil
+		}
+		if obj, ok := obj.(*Hash); ok {
+			m := reflect.MakeMapWithSize(v.Type(), len(obj.Pairs))
+			for _, pair := range obj.Pairs {
+				key := reflect.New(v.Type().Key()).Elem()
+				if err := toGo(pair.Key, key, path); err != nil {
+					return err
+				}
+				value := reflect.New(v.Type().Elem()).Elem()
+				if err := toGo(pair.Value, value, fmt.Sprintf("%s[%s]", path, objectKeyString(pair.Key))); err != nil {
+					return err
+				}
+				m.SetMapIndex(key, value)
+			}
+			v.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if obj, ok := obj.(*Hash); ok {
+			for _, field := range structFields(v.Type()) {
+				pair, ok := obj.Pairs[(&String{Value: field.name}).HashKey()]
+				if !ok {
+					continue
+				}
+				fieldValue, err := fieldByIndex(v, field.index, path+"."+field.name)
+				if err != nil {
+					return err
+				}
+				if err := toGo(pair.Value, fieldValue, path+"."+field.name); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case reflect.Func:
+		if obj == NULL {
+			v.SetZero()
+			return nil
+		}
+		if obj, ok := obj.(*Builtin); ok {
+			if obj.Fn == nil {
+				return conversionError(path, "cannot convert BUILTIN to %s, it needs a call context", v.Type())
+			}
+			fn, err := funcFromBuiltin(obj, v.Type(), path)
+			if err != nil {
+				return err
+			}
+			v.Set(fn)
+			return nil
+		}
+	}
+
+	return &typeMismatch{path: path, got: obj.Type(), want: v.Type()}
+}
+
+// An object of the wrong type for the Go value it is converted to
+type typeMismatch struct {
+	path string
+	got  ObjectType
+	want reflect.Type
+}
+
+func (e *typeMismatch) Error() string {
+	return conversionError(e.path, "cannot convert %s to %s", e.got, e.want).Error()
+}
+
+// naturalGo is the Go value obj converts to when stored in an `any`
+func naturalGo(obj Object, path string) (any, error) {
+	switch obj := obj.(type) {
+	case *Null:
+		return nil, nil
+	case *Boolean:
+		return obj.Value, nil
+	case *Integer:
+		return obj.Value, nil
+	case *Float:
+		return obj.Value, nil
+	case *String:
+		return obj.Value, nil
+	case *Array:
+		elements := make([]any, len(obj.Elements))
+		for i, element := range obj.Elements {
+			value, err := naturalGo(element, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = value
+		}
+		return elements, nil
+	case *Hash:
+		stringKeys := map[string]any{}
+		anyKeys := map[any]any{}
+		for _, pair := range obj.Pairs {
+			key, err := naturalGo(pair.Key, path)
+			if err != nil {
+				return nil, err
+			}
+			value, err := naturalGo(pair.Value, fmt.Sprintf("%s[%s]", path, objectKeyString(pair.Key)))
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := key.(string); ok {
+				stringKeys[s] = value
+			}
+			anyKeys[key] = value
+		}
+		if len(stringKeys) == len(anyKeys) {
+			return stringKeys, nil
+		}
+		return anyKeys, nil
+	default:
+		// Functions and builtins have no Go equivalent, they stay objects
+		return obj, nil
+	}
+}
+
+// NewBuiltin wraps the Go function fn as the builtin name, the number and
+// types of its arguments are checked against the parameters of fn before
+// converting them like ToGo does, so that fn only deals with Go values:
+//
+//	NewBuiltin("repeat", func(s string, n int64) (string, error) { ... })
+//
+// fn returns nothing, a value, an error or a value and an error. A returned
+// *Error is used as is, other errors become the message of one. When the
+// first parameter of fn is a CallContext, fn gets the one of the call
+func NewBuiltin(name string, fn any) (*Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("builtin %s: not a function: %T", name, fn)
+	}
+	return newBuiltin(name, v)
+}
+
+func newBuiltin(name string, fn reflect.Value) (*Builtin, error) {
+	t := fn.Type()
+	if !validResults(t) {
+		return nil, fmt.Errorf("unsupported Go type: %s, a function returns at most a value and an error", t)
+	}
+
+	if t.NumIn() > 0 && t.In(0) == callContextType {
+		return &Builtin{
+			Name: name,
+			Call: func(ctx CallContext, args ...Object) Object {
+				in, err := funcArguments(name, t, 1, args)
+				if err != nil {
+					return err
+				}
+				in = append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, in...)
+				return funcResult(fn.Call(in))
+			},
+		}, nil
+	}
+
+	return &Builtin{
+		Name: name,
+		Fn: func(args ...Object) Object {
+			in, err := funcArguments(name, t, 0, args)
+			if err != nil {
+				return err
+			}
+			return funcResult(fn.Call(in))
+		},
+	}, nil
+}
+
+func validResults(t reflect.Type) bool {
+	switch t.NumOut() {
+	case 0, 1:
+		return true
+	case 2:
+		return t.Out(1) == errorType
+	default:
+		return false
+	}
+}
+
+// funcArguments converts args to the parameters of a function of type t,
+// the builtin name, after the skip first ones
+func funcArguments(name string, t reflect.Type, skip int, args []Object) ([]reflect.Value, *Error) {
+	want := t.NumIn() - skip
+	if t.IsVariadic() {
+		if len(args) < want-1 {
+			return nil, ArityError(name, want-1, len(args), true)
+		}
+	} else if len(args) != want {
+		return nil, ArityError(name, want, len(args), false)
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		if arg == nil {
+			arg = NULL
+		}
+
+		var paramType reflect.Type
+		if t.IsVariadic() && i >= want-1 {
+			paramType = t.In(t.NumIn() - 1).Elem()
+		} else {
+			paramType = t.In(skip + i)
+		}
+
+		param := reflect.New(paramType).Elem()
+		if err := toGo(arg, param, ""); err != nil {
+			var mismatch *typeMismatch
+			if errors.As(err, &mismatch) && mismatch.path == "" {
+				return nil, ArgumentError(name, i+1, typeName(paramType), arg)
+			}
+			return nil, &Error{Message: fmt.Sprintf("argument %d%s: %s", i+1, to(name), err), Cause: err}
+		}
+		in[i] = param
+	}
+
+	return in, nil
+}
+
+// funcResult converts what a Go function returned to an object
+func funcResult(out []reflect.Value) Object {
+	if len(out) > 0 && out[len(out)-1].Type() == errorType {
+		if err, _ := out[len(out)-1].Interface().(error); err != nil {
+			if err, ok := err.(*Error); ok {
+				return err
+			}
+			return &Error{Message: err.Error(), Cause: err}
+		}
+		out = out[:len(out)-1]
+	}
+
+	if len(out) == 0 {
+		return NULL
+	}
+
+	result, err := fromGo(out[0], "", nil)
+	if err != nil {
+		return &Error{Message: "result: " + err.Error(), Cause: err}
+	}
+	return result
+}
+
+// funcFromBuiltin makes a Go function of type t calling builtin. When the
+// builtin fails, the error is returned if t returns one and panics otherwise
+func funcFromBuiltin(builtin *Builtin, t reflect.Type, path string) (reflect.Value, error) {
+	if !validResults(t) {
+		return reflect.Value{}, conversionError(path, "cannot convert BUILTIN to %s, a function returns at most a value and an error", t)
+	}
+
+	fn := func(in []reflect.Value) []reflect.Value {
+		args := []Object{}
+		for i, arg := range in {
+			if t.IsVariadic() && i == len(in)-1 {
+				for j := 0; j < arg.Len(); j++ {
+					args = append(args, mustFromGo(arg.Index(j)))
+				}
+				break
+			}
+			args = append(args, mustFromGo(arg))
+		}
+
+		var err error
+		result := builtin.Fn(args...)
+		if errObj, ok := result.(*Error); ok {
+			err = errObj
+		}
+
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.New(t.Out(i)).Elem()
+		}
+		if err == nil && t.NumOut() > 0 && t.Out(0) != errorType {
+			err = toGo(result, out[0], "result")
+		}
+
+		switch {
+		case err == nil:
+		case t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType:
+			out[t.NumOut()-1] = reflect.ValueOf(&err).Elem()
+		default:
+			panic(err)
+		}
+		return out
+	}
+
+	return reflect.MakeFunc(t, fn), nil
+}
+
+func mustFromGo(v reflect.Value) Object {
+	obj, err := fromGo(v, "", nil)
+	if err != nil {
+		panic(err)
+	}
+	return obj
+}
+
+// ArityError reports a call to the builtin name with got arguments instead
+// of want, or at least want when variadic
+func ArityError(name string, want, got int, variadic bool) *Error {
+	builtin := "builtin"
+	if name != "" {
+		builtin = "builtin " + name
+	}
+
+	atLeast := ""
+	if variadic {
+		atLeast = "at least "
+	}
+
+	arguments := "arguments"
+	if want == 1 {
+		arguments = "argument"
+	}
+
+	return &Error{Message: fmt.Sprintf("%s expects %s%d %s, got %d", builtin, atLeast, want, arguments, got)}
+}
+
+// ArgumentError reports the nth argument of the builtin name not being of a
+// type it accepts, want describes them like "ARRAY" or "STRING or ARRAY"
+func ArgumentError(name string, n int, want string, got Object) *Error {
+	return &Error{Message: fmt.Sprintf("argument %d%s must be %s, got %s", n, to(name), want, got.Type())}
+}
+
+func to(name string) string {
+	if name == "" {
+		return ""
+	}
+	return " to `" + name + "`"
+}
+
+// typeName is the type of objects accepted for the Go type t
+func typeName(t reflect.Type) string {
+	if t.Implements(objectType) && t.Kind() == reflect.Pointer {
+		return string(reflect.New(t.Elem()).Interface().(Object).Type())
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return BOOLEAN_OBJ
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return INTEGER_OBJ
+	case reflect.Float32, reflect.Float64:
+		return FLOAT_OBJ
+	case reflect.String:
+		return STRING_OBJ
+	case reflect.Slice, reflect.Array:
+		return ARRAY_OBJ
+	case reflect.Map, reflect.Struct:
+		return HASH_OBJ
+	case reflect.Func:
+		return BUILTIN_OBJ
+	case reflect.Pointer:
+		return typeName(t.Elem())
+	default:
+		return t.String()
+	}
+}
+
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields lists the fields of a struct type seen as hash keys
+func structFields(t reflect.Type) []structField {
+	fields := []structField{}
+	for _, field := range reflect.VisibleFields(t) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("monkey"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, structField{name: name, index: field.Index})
+	}
+	return fields
+}
+
+// fieldByIndex is v.FieldByIndex allocating the nil embedded pointers the
+// field is promoted from
+func fieldByIndex(v reflect.Value, index []int, path string) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, conversionError(path, "cannot set embedded pointer to unexported struct: %s", v.Type())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// set adds a pair to the hash, key must be hashable
+func (ha *Hash) set(key, value Object) error {
+	hashable, ok := key.(Hashable)
+	if !ok {
+		return errors.New("unusable as hash key: " + string(key.Type()))
+	}
+	ha.Pairs[hashable.HashKey()] = HashPair{Key: key, Value: value}
+	return nil
+}
+
+func keyString(key reflect.Value) string {
+	if key.Kind() == reflect.String {
+		return strconv.Quote(key.String())
+	}
+	return fmt.Sprint(key.Interface())
+}
+
+func objectKeyString(key Object) string {
+	if key, ok := key.(*String); ok {
+		return strconv.Quote(key.Value)
+	}
+	return key.Inspect()
+}
+
+func conversionError(path, format string, a ...any) error {
+	if path == "" {
+		return fmt.Errorf(format, a...)
+	}
+	return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, a...))
+}
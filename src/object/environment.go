@@ -1,14 +1,75 @@
 package object
 
+import (
+	"context"
+	"io"
+
+	"monkey/src/token"
+)
+
+// DefaultMaxCallDepth is how deep function calls can nest when
+// Runtime.MaxCallDepth is not set. It stays well below what exhausts the Go
+// stack, which is a fatal error that can not be recovered
+const DefaultMaxCallDepth = 10000
+
+// Runtime is the state shared by an environment and every environment
+// enclosed in it
+type Runtime struct {
+	// Maximum number of nested function calls, 0 means DefaultMaxCallDepth
+	MaxCallDepth int
+	// Function calls currently being evaluated, outermost first
+	Frames []Frame
+	// Evaluation stops when Context is done or after StepBudget nodes have
+	// been evaluated, 0 means no budget. Steps counts the nodes so far
+	Context    context.Context
+	StepBudget int
+	Steps      int
+	// The builtins programs can call, nil for the default ones of the
+	// evaluator
+	Builtins *Registry
+	// Where programs write their output, nil for os.Stdout
+	Stdout io.Writer
+}
+
+// A function call in progress
+type Frame struct {
+	Function string         // Empty for anonymous functions
+	Pos      token.Position // Where the function was called
+}
+
+func (f Frame) Name() string {
+	if f.Function == "" {
+		return "<anonymous>"
+	}
+	return f.Function
+}
+
+func (rt *Runtime) CallDepth() int {
+	return len(rt.Frames)
+}
+
+// Trace returns a copy of the current frames, safe to keep after they return
+func (rt *Runtime) Trace() []Frame {
+	return append([]Frame{}, rt.Frames...)
+}
+
+func (rt *Runtime) MaxDepth() int {
+	if rt.MaxCallDepth > 0 {
+		return rt.MaxCallDepth
+	}
+	return DefaultMaxCallDepth
+}
+
 type Environment struct {
-	pool  map[string]Object
-	outer *Environment
+	pool    map[string]Object
+	outer   *Environment
+	runtime *Runtime
 }
 
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
 
-	return &Environment{pool: s, outer: nil}
+	return &Environment{pool: s, outer: nil, runtime: &Runtime{}}
 }
 
 func (env *Environment) Get(name string) (Object, bool) {
@@ -24,8 +85,21 @@ func (env *Environment) Set(name string, val Object) Object {
 	return val
 }
 
+func (env *Environment) Runtime() *Runtime {
+	return env.runtime
+}
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.runtime = outer.runtime
+	return env
+}
+
+// NewCallEnvironment encloses outer, the environment a function was defined
+// in, but shares the runtime of the caller
+func NewCallEnvironment(outer, caller *Environment) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.runtime = caller.runtime
 	return env
 }
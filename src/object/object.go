@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"io"
+	"math"
+	"strconv"
 	"strings"
 
 	"monkey/src/ast"
+	"monkey/src/token"
 )
 
 type ObjectType string
@@ -28,6 +32,24 @@ func (i *Integer) Type() ObjectType {
 	return INTEGER_OBJ
 }
 
+type Float struct {
+	Value float64
+}
+
+// Inspect always shows a fraction or an exponent, so a float never looks
+// like an integer: 2.0, 0.5, 1e+21
+func (f *Float) Inspect() string {
+	out := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(out, ".eIN") {
+		out += ".0"
+	}
+	return out
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT_OBJ
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -54,17 +76,94 @@ func (rv *ReturnValue) Inspect() string {
 
 type Error struct {
 	Message string
+	// Location of the node that produced the error, if known
+	Pos token.Position
+	End token.Position
+	// Set when the error is a bug in the interpreter rather than in the
+	// program, Stack is the Go stack trace at the time of the failure
+	Internal bool
+	Stack    string
+	// The call stack when the error happened, outermost frame first
+	Trace []Frame
+	// The Go error that stopped the evaluation, if any
+	Cause error
+}
+
+// Error makes an *Error usable as a Go error, errors.Is sees its Cause
+func (eo *Error) Error() string {
+	return eo.Message
+}
+
+func (eo *Error) Unwrap() error {
+	return eo.Cause
 }
 
 func (eo *Error) Type() ObjectType {
 	return ERROR_OBJ
 }
 
+// Inspect prints the error after its traceback, if any:
+//
+//	Traceback (most recent call last):
+//	  main.mk:4:1, in <program>
+//	  main.mk:2:3, in add
+//	ERROR: type missmatch: INTEGER + STRING
 func (eo *Error) Inspect() string {
-	return "ERROR: " + eo.Message
+	if len(eo.Trace) == 0 {
+		return "ERROR: " + eo.Message
+	}
+
+	var out bytes.Buffer
+
+	// Each frame is at the call site of the next one, the innermost one is
+	// where the error happened
+	lines := []string{}
+	name := "<program>"
+	for _, frame := range eo.Trace {
+		lines = append(lines, fmt.Sprintf("  %s, in %s", frame.Pos, name))
+		name = frame.Name()
+	}
+	lines = append(lines, fmt.Sprintf("  %s, in %s", eo.Pos, name))
+	lines = CollapseRepeated(lines, func(n int) string {
+		return fmt.Sprintf("  [previous frame repeated %d more times]", n)
+	})
+
+	out.WriteString("Traceback (most recent call last):\n")
+	for _, line := range lines {
+		out.WriteString(line + "\n")
+	}
+	out.WriteString("ERROR: " + eo.Message)
+
+	return out.String()
+}
+
+// How many lines of a run of identical ones CollapseRepeated keeps
+const repeatedLinesShown = 3
+
+// CollapseRepeated keeps the first lines of each run of identical lines, as
+// found in the traceback of a deep recursion, and replaces the others with
+// the line repeated(n) returns, n being the number of lines left out
+func CollapseRepeated(lines []string, repeated func(n int) string) []string {
+	collapsed := []string{}
+	for i := 0; i < len(lines); {
+		run := 1
+		for i+run < len(lines) && lines[i+run] == lines[i] {
+			run++
+		}
+
+		for j := 0; j < min(run, repeatedLinesShown); j++ {
+			collapsed = append(collapsed, lines[i])
+		}
+		if run > repeatedLinesShown {
+			collapsed = append(collapsed, repeated(run-repeatedLinesShown))
+		}
+		i += run
+	}
+	return collapsed
 }
 
 type Function struct {
+	Name       string // Empty for anonymous functions
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -105,11 +204,27 @@ func (s *String) Inspect() string {
 
 type (
 	BuiltinFunction func(args ...Object) Object
-	Builtin         struct {
-		Fn BuiltinFunction
+	// A builtin needing the evaluator, to call the functions it is given
+	BuiltinCall func(ctx CallContext, args ...Object) Object
+	// Either Fn or Call is set
+	Builtin struct {
+		Name string // Empty for Go functions converted by FromGo
+		Fn   BuiltinFunction
+		Call BuiltinCall
 	}
 )
 
+// CallContext is what the evaluator gives to a builtin it calls
+type CallContext interface {
+	// Apply calls fn, a function or a builtin, with args. The result is an
+	// *Error if the call failed
+	Apply(fn Object, args ...Object) Object
+	// Out is where the program writes its output
+	Out() io.Writer
+	// Env is the environment the builtin is called from
+	Env() *Environment
+}
+
 func (bf *Builtin) Type() ObjectType {
 	return BUILTIN_OBJ
 }
@@ -162,6 +277,19 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// Floats equal to an integer hash like that integer, since 1 == 1.0 they are
+// the same key. -0.0 is the same key as 0 and every NaN is the same key
+func (f *Float) HashKey() HashKey {
+	v := f.Value
+	if v == math.Trunc(v) && v >= math.MinInt64 && v < math.MaxInt64 {
+		return HashKey{Type: INTEGER_OBJ, Value: uint64(int64(v))}
+	}
+	if math.IsNaN(v) {
+		v = math.NaN()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(v)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
@@ -209,8 +337,17 @@ func (null *Null) Inspect() string {
 	return "null"
 }
 
+// There is a single null and a single object for each boolean, they can be
+// compared by identity
+var (
+	NULL  = &Null{}
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+)
+
 const (
 	INTEGER_OBJ  = "INTEGER"
+	FLOAT_OBJ    = "FLOAT"
 	BOOLEAN_OBJ  = "BOOLEAN"
 	NULL_OBJ     = "NULL"
 	RETURN_OBJ   = "RETURN"
@@ -1,6 +1,15 @@
 package object
 
-import "testing"
+import (
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+
+	"monkey/src/token"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello"}
@@ -20,3 +29,448 @@ func TestStringHashKey(t *testing.T) {
 		t.Fatalf("Same hashkey but different value")
 	}
 }
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 1.5}).HashKey() != (&Float{Value: 1.5}).HashKey() {
+		t.Fatalf("Different hashkey for the same value")
+	}
+
+	if (&Float{Value: 1.5}).HashKey() == (&Float{Value: 2.5}).HashKey() {
+		t.Fatalf("Same hashkey but different value")
+	}
+
+	if (&Float{Value: 3}).HashKey() != (&Integer{Value: 3}).HashKey() {
+		t.Fatalf("Integral float does not hash like the integer")
+	}
+
+	if (&Float{Value: math.Copysign(0, -1)}).HashKey() != (&Float{Value: 0}).HashKey() {
+		t.Fatalf("-0.0 and 0.0 have different hashkeys")
+	}
+
+	if (&Float{Value: math.NaN()}).HashKey() != (&Float{Value: -math.NaN()}).HashKey() {
+		t.Fatalf("NaNs have different hashkeys")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{2, "2.0"},
+		{0.5, "0.5"},
+		{-1.25, "-1.25"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.NaN(), "NaN"},
+	}
+
+	for _, tt := range tests {
+		f := &Float{Value: tt.value}
+		if f.Inspect() != tt.expected {
+			t.Errorf("Inspect wrong. Expected: %s, got: %s", tt.expected, f.Inspect())
+		}
+	}
+}
+
+func TestErrorInspect(t *testing.T) {
+	pos := func(line, column int) token.Position {
+		return token.Position{Filename: "main.mk", Line: line, Column: column}
+	}
+
+	tests := []struct {
+		err      *Error
+		expected string
+	}{
+		{&Error{Message: "boom"}, "ERROR: boom"},
+		{
+			&Error{
+				Message: "type missmatch: INTEGER + STRING",
+				Pos:     pos(2, 3),
+				Trace: []Frame{
+					{Function: "twice", Pos: pos(7, 1)},
+					{Function: "", Pos: pos(5, 10)},
+					{Function: "add", Pos: pos(4, 5)},
+				},
+			},
+			"Traceback (most recent call last):\n" +
+				"  main.mk:7:1, in <program>\n" +
+				"  main.mk:5:10, in twice\n" +
+				"  main.mk:4:5, in <anonymous>\n" +
+				"  main.mk:2:3, in add\n" +
+				"ERROR: type missmatch: INTEGER + STRING",
+		},
+		{
+			&Error{
+				Message: "maximum recursion depth exceeded: 6 calls deep in function f",
+				Pos:     pos(1, 5),
+				Trace: []Frame{
+					{Function: "f", Pos: pos(2, 1)},
+					{Function: "f", Pos: pos(1, 5)},
+					{Function: "f", Pos: pos(1, 5)},
+					{Function: "f", Pos: pos(1, 5)},
+					{Function: "f", Pos: pos(1, 5)},
+					{Function: "f", Pos: pos(1, 5)},
+				},
+			},
+			"Traceback (most recent call last):\n" +
+				"  main.mk:2:1, in <program>\n" +
+				"  main.mk:1:5, in f\n" +
+				"  main.mk:1:5, in f\n" +
+				"  main.mk:1:5, in f\n" +
+				"  [previous frame repeated 3 more times]\n" +
+				"ERROR: maximum recursion depth exceeded: 6 calls deep in function f",
+		},
+	}
+
+	for _, tt := range tests {
+		if tt.err.Inspect() != tt.expected {
+			t.Errorf("Inspect wrong. Expected:\n%s\ngot:\n%s", tt.expected, tt.err.Inspect())
+		}
+	}
+}
+
+type testLimits struct {
+	Max     int64   `monkey:"max"`
+	Ratio   float64 `monkey:"ratio"`
+	Secret  string  `monkey:"-"`
+	hidden  bool
+	Enabled bool
+}
+
+type testRule struct {
+	testLimits
+	Name string   `monkey:"name"`
+	Tags []string `monkey:"tags"`
+	Next *testRule
+}
+
+// Quota is exported for ToGo to allocate it when embedded by pointer
+type Quota struct {
+	Burst int64 `monkey:"burst"`
+}
+
+type testPlan struct {
+	*Quota
+	Name string
+}
+
+type testAccount struct {
+	*testLimits
+	Name string
+}
+
+func TestFromGo(t *testing.T) {
+	var nilSlice []int
+	var nilRule *testRule
+	sharedSlice := []int{1}
+
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{nil, "null"},
+		{true, "true"},
+		{int8(-3), "-3"},
+		{uint32(7), "7"},
+		{2.5, "2.5"},
+		{float32(1), "1.0"},
+		{"hi", "hi"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]bool{true, false}, "[true, false]"},
+		{nilSlice, "null"},
+		{nilRule, "null"},
+		{map[string]int{"a": 1}, "{a: 1}"},
+		{&testObject{}, "test object"},
+		{[]any{1, "two", nil}, "[1, two, null]"},
+		// Fields promoted from a nil embedded pointer are left out
+		{testPlan{Name: "p"}, "{Name: p}"},
+		{testPlan{Quota: &Quota{Burst: 2}, Name: "p"}, "{Name: p, burst: 2}"},
+		// Shared but not cyclic
+		{[][]int{sharedSlice, sharedSlice}, "[[1], [1]]"},
+		{
+			testRule{testLimits: testLimits{Max: 3, Secret: "s", Enabled: true}, Name: "r", Tags: []string{"x"}},
+			"{Enabled: true, Next: null, max: 3, name: r, ratio: 0.0, tags: [x]}",
+		},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v) unexpected error: %v", tt.input, err)
+			continue
+		}
+		if inspectSorted(obj) != tt.expected {
+			t.Errorf("FromGo(%#v) wrong object. Expected: %s, got: %s", tt.input, tt.expected, inspectSorted(obj))
+		}
+	}
+}
+
+func TestFromGoErrors(t *testing.T) {
+	cyclicRule := &testRule{Name: "r"}
+	cyclicRule.Next = &testRule{Next: cyclicRule}
+	cyclicSlice := []any{1, nil}
+	cyclicSlice[1] = cyclicSlice
+	cyclicMap := map[string]any{}
+	cyclicMap["self"] = cyclicMap
+
+	tests := []struct {
+		input    any
+		expected string
+	}{
+		{make(chan int), "unsupported Go type: chan int"},
+		{[]any{1, complex(1, 2)}, "[1]: unsupported Go type: complex128"},
+		{map[string]any{"k": []uint64{1 << 63}}, `["k"][0]: 9223372036854775808 overflows INTEGER`},
+		{func() (int, int) { return 0, 0 }, "unsupported Go type: func() (int, int), a function returns at most a value and an error"},
+		{map[[2]int]int{{1, 2}: 3}, "unusable as hash key: ARRAY"},
+		{cyclicRule, ".Next.Next: unsupported cyclic value: *object.testRule"},
+		{cyclicSlice, "[1]: unsupported cyclic value: []interface {}"},
+		{cyclicMap, `["self"]: unsupported cyclic value: map[string]interface {}`},
+	}
+
+	for _, tt := range tests {
+		_, err := FromGo(tt.input)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("FromGo(%T) wrong error. Expected: %s, got: %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestToGo(t *testing.T) {
+	rule := &Hash{Pairs: map[HashKey]HashPair{}}
+	rule.set(&String{Value: "name"}, &String{Value: "r"})
+	rule.set(&String{Value: "max"}, &Integer{Value: 3})
+	rule.set(&String{Value: "ratio"}, &Integer{Value: 2})
+	rule.set(&String{Value: "tags"}, &Array{Elements: []Object{&String{Value: "x"}}})
+	rule.set(&String{Value: "Next"}, NULL)
+	rule.set(&String{Value: "unknown"}, TRUE)
+
+	var got testRule
+	if err := ToGo(rule, &got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Name != "r" || got.Max != 3 || got.Ratio != 2 || len(got.Tags) != 1 || got.Tags[0] != "x" || got.Next != nil {
+		t.Errorf("wrong struct, got: %+v", got)
+	}
+
+	var natural any
+	ToGo(&Array{Elements: []Object{&Integer{Value: 1}, rule, NULL}}, &natural)
+	elements, ok := natural.([]any)
+	if !ok || elements[0] != int64(1) || elements[2] != nil {
+		t.Fatalf("wrong natural value, got: %#v", natural)
+	}
+	if hash, ok := elements[1].(map[string]any); !ok || hash["name"] != "r" {
+		t.Errorf("wrong natural hash, got: %#v", elements[1])
+	}
+
+	byID := &Hash{Pairs: map[HashKey]HashPair{}}
+	byID.set(&Integer{Value: 1}, &Integer{Value: 255})
+	var counts map[int]uint8
+	if err := ToGo(byID, &counts); err != nil || counts[1] != 255 {
+		t.Errorf("wrong map, got: %v (%v)", counts, err)
+	}
+
+	var obj Object
+	if err := ToGo(TRUE, &obj); err != nil || obj != TRUE {
+		t.Errorf("object not kept as is, got: %v (%v)", obj, err)
+	}
+
+	// A nil object is NULL
+	limit := new(int64)
+	if err := ToGo(nil, &limit); err != nil || limit != nil {
+		t.Errorf("nil object not converted to nil, got: %v (%v)", limit, err)
+	}
+
+	// Embedded pointers are allocated for the fields set through them only
+	burst := &Hash{Pairs: map[HashKey]HashPair{}}
+	burst.set(&String{Value: "burst"}, &Integer{Value: 5})
+	var plan, emptyPlan testPlan
+	if err := ToGo(burst, &plan); err != nil || plan.Quota == nil || plan.Burst != 5 {
+		t.Errorf("wrong plan, got: %+v (%v)", plan, err)
+	}
+	if err := ToGo(&Hash{Pairs: map[HashKey]HashPair{}}, &emptyPlan); err != nil || emptyPlan.Quota != nil {
+		t.Errorf("wrong empty plan, got: %+v (%v)", emptyPlan, err)
+	}
+}
+
+func TestToGoErrors(t *testing.T) {
+	tags := &Hash{Pairs: map[HashKey]HashPair{}}
+	tags.set(&String{Value: "tags"}, &Array{Elements: []Object{&String{Value: "x"}, &Integer{Value: 1}}})
+
+	var i int8
+	var u uint
+	var s string
+	var rule testRule
+	var pair [2]int
+	var notPointer int
+	var fn func(...any)
+	put := &Builtin{Name: "put", Call: func(ctx CallContext, args ...Object) Object { return NULL }}
+	var account testAccount
+	limits := &Hash{Pairs: map[HashKey]HashPair{}}
+	limits.set(&String{Value: "max"}, &Integer{Value: 1})
+
+	tests := []struct {
+		obj      Object
+		target   any
+		expected string
+	}{
+		{&String{Value: "1"}, &i, "cannot convert STRING to int8"},
+		{&Integer{Value: 300}, &i, "300 overflows int8"},
+		{&Integer{Value: -1}, &u, "-1 overflows uint"},
+		{&Float{Value: 1}, &s, "cannot convert FLOAT to string"},
+		{tags, &rule, ".tags[1]: cannot convert INTEGER to string"},
+		{&Array{Elements: []Object{&Integer{Value: 1}}}, &pair, "cannot convert ARRAY of 1 elements to [2]int"},
+		{TRUE, notPointer, "target must be a non-nil pointer, got: int"},
+		{put, &fn, "cannot convert BUILTIN to func(...interface {}), it needs a call context"},
+		{limits, &account, ".max: cannot set embedded pointer to unexported struct: *object.testLimits"},
+	}
+
+	for _, tt := range tests {
+		err := ToGo(tt.obj, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("ToGo(%s, %T) wrong error. Expected: %s, got: %v", tt.obj.Inspect(), tt.target, tt.expected, err)
+		}
+	}
+}
+
+func TestGoFunctions(t *testing.T) {
+	obj, err := FromGo(func(s string, n int) (string, error) {
+		if n < 0 {
+			return "", errors.New("negative count")
+		}
+		return strings.Repeat(s, n), nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	builtin := obj.(*Builtin)
+
+	tests := []struct {
+		args     []Object
+		expected string
+	}{
+		{[]Object{&String{Value: "ab"}, &Integer{Value: 2}}, "abab"},
+		{[]Object{&String{Value: "ab"}, &Integer{Value: -1}}, "ERROR: negative count"},
+		{[]Object{&String{Value: "ab"}}, "ERROR: builtin expects 2 arguments, got 1"},
+		{[]Object{&Integer{Value: 1}, &Integer{Value: 2}}, "ERROR: argument 1 must be STRING, got INTEGER"},
+	}
+
+	for _, tt := range tests {
+		if result := builtin.Fn(tt.args...); result.Inspect() != tt.expected {
+			t.Errorf("wrong result. Expected: %s, got: %s", tt.expected, result.Inspect())
+		}
+	}
+
+	sum, _ := FromGo(func(xs ...float64) float64 {
+		total := 0.0
+		for _, x := range xs {
+			total += x
+		}
+		return total
+	})
+	if result := sum.(*Builtin).Fn(&Integer{Value: 1}, &Float{Value: 0.5}); result.Inspect() != "1.5" {
+		t.Errorf("wrong variadic result, got: %s", result.Inspect())
+	}
+
+	// And back to Go
+	var repeat func(string, int) (string, error)
+	if err := ToGo(builtin, &repeat); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s, err := repeat("x", 3); s != "xxx" || err != nil {
+		t.Errorf("wrong result, got: %q, %v", s, err)
+	}
+	if _, err := repeat("x", -1); err == nil || err.Error() != "negative count" {
+		t.Errorf("wrong error, got: %v", err)
+	}
+}
+
+// An Object implemented outside of the known types
+type testObject struct{}
+
+func (o *testObject) Type() ObjectType { return "TEST" }
+func (o *testObject) Inspect() string  { return "test object" }
+
+// inspectSorted is Inspect with the pairs of hashes in key order
+func inspectSorted(obj Object) string {
+	hash, ok := obj.(*Hash)
+	if !ok {
+		return obj.Inspect()
+	}
+
+	pairs := []string{}
+	for _, pair := range hash.Pairs {
+		pairs = append(pairs, pair.Key.Inspect()+": "+inspectSorted(pair.Value))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	if err := registry.Register("one", func() int64 { return 1 }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	registry.Set("two", &Builtin{Name: "two", Fn: func(args ...Object) Object { return &Integer{Value: 2} }})
+
+	clone := registry.Clone()
+	clone.Unregister("one")
+	clone.Register("three", func() int64 { return 3 })
+
+	if names := strings.Join(registry.Names(), " "); names != "one two" {
+		t.Errorf("wrong names. Expected: one two, got: %s", names)
+	}
+	if names := strings.Join(clone.Names(), " "); names != "three two" {
+		t.Errorf("wrong clone names. Expected: three two, got: %s", names)
+	}
+
+	one, ok := registry.Get("one")
+	if !ok || one.Name != "one" || one.Fn().Inspect() != "1" {
+		t.Errorf("wrong builtin, got: %+v", one)
+	}
+	if _, ok := clone.Get("one"); ok {
+		t.Errorf("one not removed from the clone")
+	}
+	if err := registry.Register("bad", "not a function"); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+type testCallContext struct{ calls int }
+
+func (ctx *testCallContext) Apply(fn Object, args ...Object) Object {
+	ctx.calls++
+	return fn.(*Builtin).Fn(args...)
+}
+func (ctx *testCallContext) Out() io.Writer    { return io.Discard }
+func (ctx *testCallContext) Env() *Environment { return nil }
+
+func TestBuiltinWithCallContext(t *testing.T) {
+	apply, err := NewBuiltin("apply", func(ctx CallContext, fn Object, n int64) Object {
+		return ctx.Apply(fn, &Integer{Value: n})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if apply.Fn != nil || apply.Call == nil {
+		t.Fatalf("builtin does not take a call context")
+	}
+
+	double, _ := NewBuiltin("double", func(n int64) int64 { return n * 2 })
+	ctx := &testCallContext{}
+
+	if result := apply.Call(ctx, double, &Integer{Value: 4}); result.Inspect() != "8" || ctx.calls != 1 {
+		t.Errorf("wrong result, got: %s after %d calls", result.Inspect(), ctx.calls)
+	}
+	if result := apply.Call(ctx, double); result.Inspect() != "ERROR: builtin apply expects 2 arguments, got 1" {
+		t.Errorf("wrong arity error, got: %s", result.Inspect())
+	}
+	if result := apply.Call(ctx, double, TRUE); result.Inspect() != "ERROR: argument 2 to `apply` must be INTEGER, got BOOLEAN" {
+		t.Errorf("wrong argument error, got: %s", result.Inspect())
+	}
+
+	var fn func(int64) Object
+	if err := ToGo(apply, &fn); err == nil {
+		t.Errorf("a builtin needing the evaluator converted to a Go function")
+	}
+}
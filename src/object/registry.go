@@ -0,0 +1,74 @@
+package object
+
+import (
+	"sort"
+	"sync"
+)
+
+// A Registry holds the builtins a program can call by name. It is safe for
+// concurrent use, several interpreters may share one
+type Registry struct {
+	mu       sync.RWMutex
+	builtins map[string]*Builtin
+}
+
+func NewRegistry() *Registry {
+	return &Registry{builtins: map[string]*Builtin{}}
+}
+
+// Register makes the Go function fn available as the builtin name, see
+// NewBuiltin for the functions accepted
+func (r *Registry) Register(name string, fn any) error {
+	builtin, err := NewBuiltin(name, fn)
+	if err != nil {
+		return err
+	}
+	r.Set(name, builtin)
+	return nil
+}
+
+// Set makes builtin available as name, replacing any builtin of that name
+func (r *Registry) Set(name string, builtin *Builtin) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.builtins[name] = builtin
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.builtins, name)
+}
+
+func (r *Registry) Get(name string) (*Builtin, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	builtin, ok := r.builtins[name]
+	return builtin, ok
+}
+
+// Names lists the registered builtins in alphabetical order
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.builtins))
+	for name := range r.builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Clone returns a registry with the same builtins, changing one of them
+// does not change the other
+func (r *Registry) Clone() *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	clone := NewRegistry()
+	for name, builtin := range r.builtins {
+		clone.builtins[name] = builtin
+	}
+	return clone
+}
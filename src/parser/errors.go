@@ -0,0 +1,50 @@
+package parser
+
+import (
+	"monkey/src/token"
+)
+
+// What went wrong while parsing
+type ErrorKind int
+
+const (
+	// A token other than the expected one(s) was found
+	UnexpectedToken ErrorKind = iota
+	// The token can not start an expression
+	NoPrefixParseFn
+	// The literal could not be turned into a value, e.g. an integer overflow
+	InvalidLiteral
+	// Reported by the lexer, e.g. an illegal character
+	LexicalError
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case UnexpectedToken:
+		return "unexpected token"
+	case NoPrefixParseFn:
+		return "no prefix parse function"
+	case InvalidLiteral:
+		return "invalid literal"
+	case LexicalError:
+		return "lexical error"
+	default:
+		return "unknown error"
+	}
+}
+
+// A single syntax error found by the parser
+type ParseError struct {
+	Pos      token.Position
+	Kind     ErrorKind
+	Expected []token.TokenType // Token types that would have been accepted, if any
+	Actual   token.Token       // The offending token
+	Message  string
+}
+
+// Error returns the message prefixed with the position, e.g.
+//
+//	main.mk:2:7: Expect token to be =, got INT instead
+func (pe *ParseError) Error() string {
+	return pe.Pos.String() + ": " + pe.Message
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"monkey/src/ast"
 	"monkey/src/lexer"
@@ -17,11 +18,22 @@ type (
 type Parser struct {
 	l *lexer.Lexer
 
-	errors []string
+	errors []*ParseError
+	// Number of lexer errors already copied into errors
+	lexErrors int
+	// Set after an error until the parser has synchronized on the next
+	// statement, errors reported meanwhile are cascades and are dropped
+	panicMode bool
 
 	curToken  token.Token
 	peekToken token.Token
 
+	// Doc comments right before curToken and peekToken
+	curDoc  *ast.CommentGroup
+	peekDoc *ast.CommentGroup
+	// Number of lexer comments already looked at
+	comments int
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
@@ -29,7 +41,7 @@ type Parser struct {
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []*ParseError{},
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
@@ -44,8 +56,10 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -54,8 +68,19 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
 
 	p.nextToken()
 	p.nextToken()
@@ -65,7 +90,21 @@ func New(l *lexer.Lexer) *Parser {
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
+	p.curDoc = p.peekDoc
 	p.peekToken = p.l.NextToken()
+	p.peekDoc = p.docComment()
+
+	// Lexical errors are always reported, they are never a cascade of an
+	// earlier syntax error
+	for _, err := range p.l.Errors()[p.lexErrors:] {
+		p.errors = append(p.errors, &ParseError{
+			Pos:     err.Pos,
+			Kind:    LexicalError,
+			Actual:  p.peekToken,
+			Message: err.Message,
+		})
+	}
+	p.lexErrors = len(p.l.Errors())
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -90,24 +129,69 @@ func (p *Parser) registerInfix(tokType token.TokenType, fn infixParseFn) {
 	p.infixParseFns[tokType] = fn
 }
 
+// parseStatement parses one statement. When it contains a syntax error the
+// parser skips to the end of the statement, so the error is reported once and
+// parsing goes on with the next statement
 func (p *Parser) parseStatement() ast.Statement {
+	var stm ast.Statement
+
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		stm = p.parseLetStatement()
 	case token.RETURN:
-		return p.parseReturnStatement()
+		stm = p.parseReturnStatement()
 	default:
-		return p.parseExpressionStatement()
+		stm = p.parseExpressionStatement()
 	}
+
+	if p.panicMode {
+		p.synchronize()
+		if bad, ok := stm.(*ast.BadStatement); ok {
+			bad.To = p.curToken.End
+		}
+	}
+
+	return stm
 }
 
-func (p *Parser) parseLetStatement() *ast.LetStatement {
+// synchronize skips tokens until the current token ends a statement: it is a
+// `;`, or the next token starts a new statement or closes the block. Blocks
+// opened while skipping are skipped as a whole
+func (p *Parser) synchronize() {
+	defer func() { p.panicMode = false }()
+
+	depth := 0
+	for !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		}
+
+		if depth == 0 {
+			if p.curTokenIs(token.SEMICOLON) {
+				return
+			}
+			switch p.peekToken.Type {
+			case token.LET, token.RETURN, token.RBRACE, token.EOF:
+				return
+			}
+		}
+		p.nextToken()
+	}
+}
+
+func (p *Parser) parseLetStatement() ast.Statement {
 	stm := &ast.LetStatement{
 		Token: p.curToken,
+		Doc:   p.curDoc,
 	}
 
 	if !p.expectPeek(token.IDENT) {
-		return nil
+		return &ast.BadStatement{Token: stm.Token}
 	}
 
 	stm.Name = &ast.Identifier{
@@ -116,11 +200,15 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	}
 
 	if !p.expectPeek(token.ASSIGN) {
-		return nil
+		return &ast.BadStatement{Token: stm.Token}
 	}
 	p.nextToken()
 	stm.Value = p.parseExpression(LOWEST)
 
+	if fl, ok := stm.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stm.Name.Value
+	}
+
 	for p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -136,7 +224,7 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 
 	stm.ReturnValue = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
@@ -162,7 +250,7 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
-		return nil
+		return p.badExpression(p.curToken)
 	}
 
 	leftExp := prefix()
@@ -197,10 +285,15 @@ func (p *Parser) parseArrayLiteral() ast.Expression {
 		Token: p.curToken,
 	}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if array.Elements == nil {
+		return p.badExpression(array.Token)
+	}
+	array.Rbracket = p.curToken.Pos
 
 	return array
 }
 
+// parseExpressionList returns nil when the list is not closed by end
 func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
 	list := []ast.Expression{}
 
@@ -231,15 +324,20 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp.Index = p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RBRACKET) {
-		return nil
+		return p.badExpression(exp.Token)
 	}
+	exp.Rbracket = p.curToken.Pos
 
 	return exp
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(&ParseError{
+		Pos:     p.curToken.Pos,
+		Kind:    NoPrefixParseFn,
+		Actual:  p.curToken,
+		Message: fmt.Sprintf("no prefix parse function for %s found", t),
+	})
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
@@ -247,9 +345,31 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %s as an integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		p.addError(&ParseError{
+			Pos:     p.curToken.Pos,
+			Kind:    InvalidLiteral,
+			Actual:  p.curToken,
+			Message: fmt.Sprintf("could not parse %s as an integer", p.curToken.Literal),
+		})
+		return p.badExpression(p.curToken)
+	}
+
+	lit.Value = value
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addError(&ParseError{
+			Pos:     p.curToken.Pos,
+			Kind:    InvalidLiteral,
+			Actual:  p.curToken,
+			Message: fmt.Sprintf("could not parse %s as a float", p.curToken.Literal),
+		})
+		return p.badExpression(p.curToken)
 	}
 
 	lit.Value = value
@@ -264,6 +384,10 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.currPredence()
+	if p.curTokenIs(token.POWER) {
+		// Right associative: 2 ** 3 ** 2 is 2 ** (3 ** 2)
+		precedence--
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 
@@ -279,12 +403,13 @@ func (p *Parser) parseBoolean() ast.Expression {
 }
 
 func (p *Parser) parseGroupExpression() ast.Expression {
+	lparen := p.curToken
 	p.nextToken()
 
 	exp := p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(lparen)
 	}
 	return exp
 }
@@ -295,18 +420,18 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	}
 
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
 
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
 
 	expression.Consequence = p.parseBlockStatement()
@@ -315,7 +440,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 		p.nextToken()
 
 		if !p.expectPeek(token.LBRACE) {
-			return nil
+			return p.badExpression(expression.Token)
 		}
 		expression.Alternative = p.parseBlockStatement()
 	}
@@ -341,6 +466,10 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		p.nextToken()
 	}
 
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken.Pos
+	}
+
 	return block
 }
 
@@ -354,7 +483,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		key := p.parseExpression(LOWEST)
 
 		if !p.expectPeek(token.COLON) {
-			return nil
+			return p.badExpression(hash.Token)
 		}
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
@@ -362,12 +491,13 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		hash.Pairs[key] = value
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
-			return nil
+			return p.badExpression(hash.Token)
 		}
 	}
 	if !p.expectPeek(token.RBRACE) {
-		return nil
+		return p.badExpression(hash.Token)
 	}
+	hash.Rbrace = p.curToken.Pos
 
 	return hash
 }
@@ -380,13 +510,16 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(lit.Token)
 	}
 
 	lit.Parameters = p.parseFunctionParameters()
+	if lit.Parameters == nil {
+		return p.badExpression(lit.Token)
+	}
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(lit.Token)
 	}
 
 	lit.Body = p.parseBlockStatement()
@@ -394,6 +527,8 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	return lit
 }
 
+// parseFunctionParameters returns nil when the parameters are not closed by
+// a parenthesis
 func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	identifiers := []*ast.Identifier{}
 
@@ -423,6 +558,10 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	if exp.Arguments == nil {
+		return p.badExpression(exp.Token)
+	}
+	exp.Rparen = p.curToken.Pos
 	return exp
 }
 
@@ -466,38 +605,127 @@ func (p *Parser) expectPeek(token token.TokenType) bool {
 	}
 }
 
+// Errors returns the parse errors as strings, see ParseErrors for the
+// structured form
 func (p *Parser) Errors() []string {
+	errors := make([]string, 0, len(p.errors))
+	for _, err := range p.errors {
+		errors = append(errors, err.Error())
+	}
+	return errors
+}
+
+// docComment returns the group of /// comments lexed right before the peek
+// token, which ends on the line above it, or nil when there is none
+func (p *Parser) docComment() *ast.CommentGroup {
+	comments := p.l.Comments()[p.comments:]
+	p.comments = len(p.l.Comments())
+
+	line := p.peekToken.Pos.Line
+	group := []*ast.Comment{}
+	for i := len(comments) - 1; i >= 0; i-- {
+		c := comments[i]
+		if !strings.HasPrefix(c.Literal, "///") || c.Pos.Line != line-1 {
+			break
+		}
+		group = append([]*ast.Comment{{Token: c}}, group...)
+		line--
+	}
+
+	// A trailing comment of the previous line is not a doc comment
+	if len(group) == 0 || group[0].Pos().Line <= p.curToken.End.Line {
+		return nil
+	}
+	return &ast.CommentGroup{List: group}
+}
+
+// addError records err unless the parser is still recovering from a previous
+// error, or an error was already reported at the same position or by the
+// lexer inside the offending token
+func (p *Parser) addError(err *ParseError) {
+	if p.panicMode {
+		return
+	}
+	p.panicMode = true
+
+	for i := len(p.errors) - 1; i >= 0; i-- {
+		prev := p.errors[i]
+		if prev.Pos == err.Pos {
+			return
+		}
+		inToken := prev.Pos.Offset >= err.Actual.Pos.Offset && prev.Pos.Offset < err.Actual.End.Offset
+		if prev.Kind == LexicalError && prev.Pos.Filename == err.Pos.Filename && inToken {
+			return
+		}
+		if prev.Pos.Offset < err.Actual.Pos.Offset {
+			break
+		}
+	}
+	p.errors = append(p.errors, err)
+}
+
+// badExpression stands in for an expression that failed to parse, spanning
+// from the given token up to the current one
+func (p *Parser) badExpression(from token.Token) ast.Expression {
+	return &ast.BadExpression{Token: from, To: p.curToken.End}
+}
+
+// ParseErrors returns every syntax error found so far, in source order
+func (p *Parser) ParseErrors() []*ParseError {
 	return p.errors
 }
 
-func (p *Parser) peekError(token token.TokenType) {
-	msg := fmt.Sprintf("Expect token to be %s, got %s instead", token, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+func (p *Parser) peekError(t token.TokenType) {
+	p.addError(&ParseError{
+		Pos:      p.peekToken.Pos,
+		Kind:     UnexpectedToken,
+		Expected: []token.TokenType{t},
+		Actual:   p.peekToken,
+		Message:  fmt.Sprintf("Expect token to be %s, got %s instead", t, p.peekToken.Type),
+	})
 }
 
+// The binary operators are ordered like in go: << >> & bind like * and | ^
+// like +, so `1 + 2 << 3` is `1 + (2 << 3)` and `x & 1 == 0` is
+// `(x & 1) == 0`. ** binds tighter than a prefix operator on its left, so
+// -2 ** 2 is -4 like in python
 const (
 	_ int = iota
 	LOWEST
+	LOGICAL_OR
+	LOGICAL_AND
 	EQUALS
 	LESSGREATER
 	SUM
 	PRODUCT
 	PREFIX
+	POWER
 	CALL
 	INDEX
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.ASTERISK: PRODUCT,
-	token.SLASH:    PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.OR:          LOGICAL_OR,
+	token.AND:         LOGICAL_AND,
+	token.EQ:          EQUALS,
+	token.NOT_EQ:      EQUALS,
+	token.LT:          LESSGREATER,
+	token.GT:          LESSGREATER,
+	token.LT_EQ:       LESSGREATER,
+	token.GT_EQ:       LESSGREATER,
+	token.PLUS:        SUM,
+	token.MINUS:       SUM,
+	token.PIPE:        SUM,
+	token.CARET:       SUM,
+	token.ASTERISK:    PRODUCT,
+	token.SLASH:       PRODUCT,
+	token.PERCENT:     PRODUCT,
+	token.AMPERSAND:   PRODUCT,
+	token.SHIFT_LEFT:  PRODUCT,
+	token.SHIFT_RIGHT: PRODUCT,
+	token.POWER:       POWER,
+	token.LPAREN:      CALL,
+	token.LBRACKET:    INDEX,
 }
 
 func (p *Parser) peekPredence() int {
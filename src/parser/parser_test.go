@@ -6,6 +6,7 @@ import (
 
 	"monkey/src/ast"
 	"monkey/src/lexer"
+	"monkey/src/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -163,6 +164,7 @@ func TestParsingPrefixExpressions(t *testing.T) {
 		integerValue interface{}
 	}{
 		{"!5", "!", 5},
+		{"~5", "~", 5},
 		{"-15", "-", 15},
 		{"!true", "!", true},
 		{"!false", "!", false},
@@ -213,6 +215,17 @@ func TestParsingInfixExpression(t *testing.T) {
 		{"5 < 5", 5, 5, "<"},
 		{"5 == 5", 5, 5, "=="},
 		{"5 != 5", 5, 5, "!="},
+		{"5 & 5", 5, 5, "&"},
+		{"5 | 5", 5, 5, "|"},
+		{"5 ^ 5", 5, 5, "^"},
+		{"5 << 5", 5, 5, "<<"},
+		{"5 >> 5", 5, 5, ">>"},
+		{"5 <= 5", 5, 5, "<="},
+		{"5 >= 5", 5, 5, ">="},
+		{"5 % 5", 5, 5, "%"},
+		{"5 ** 5", 5, 5, "**"},
+		{"true && false", true, false, "&&"},
+		{"true || false", true, false, "||"},
 		{"true == true", true, true, "=="},
 		{"true != false", true, false, "!="},
 	}
@@ -306,6 +319,78 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a + b * c + d / e - f",
 			"(((a + (b * c)) + (d / e)) - f)",
 		},
+		{
+			"a | b ^ c & d",
+			"((a | b) ^ (c & d))",
+		},
+		{
+			"a & b << c + d",
+			"(((a & b) << c) + d)",
+		},
+		{
+			"1 + 2 << 3",
+			"(1 + (2 << 3))",
+		},
+		{
+			"a >> b << c",
+			"((a >> b) << c)",
+		},
+		{
+			"x & 1 == 0",
+			"((x & 1) == 0)",
+		},
+		{
+			"a < b | c",
+			"(a < (b | c))",
+		},
+		{
+			"~a & -b",
+			"((~a) & (-b))",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a + b % c",
+			"(a + (b % c))",
+		},
+		{
+			"a * b ** c",
+			"(a * (b ** c))",
+		},
+		{
+			"a ** b ** c",
+			"(a ** (b ** c))",
+		},
+		{
+			"-a ** b",
+			"(-(a ** b))",
+		},
+		{
+			"a ** -b",
+			"(a ** (-b))",
+		},
+		{
+			"f(a) ** b[0]",
+			"(f(a) ** (b[0]))",
+		},
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a == b && c != d",
+			"((a == b) && (c != d))",
+		},
+		{
+			"!a || b < c",
+			"((!a) || (b < c))",
+		},
 		{
 			"3 + 4;-5 * 5",
 			"(3 + 4)((-5) * 5)",
@@ -657,6 +742,39 @@ func TestIfElseExpression(t *testing.T) {
 	}
 }
 
+func TestFunctionLiteralName(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedName string
+	}{
+		{"let add = fn(x, y) { x + y };", "add"},
+		{"fn(x) { x };", ""},
+		{"let f = g(fn(x) { x });", ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserError(t, p)
+
+		var fl *ast.FunctionLiteral
+		switch stm := program.Statements[0].(type) {
+		case *ast.LetStatement:
+			if call, ok := stm.Value.(*ast.CallExpression); ok {
+				fl = call.Arguments[0].(*ast.FunctionLiteral)
+			} else {
+				fl = stm.Value.(*ast.FunctionLiteral)
+			}
+		case *ast.ExpressionStatement:
+			fl = stm.Expression.(*ast.FunctionLiteral)
+		}
+
+		if fl.Name != tt.expectedName {
+			t.Errorf("wrong function name for %q. Expected: %q, got: %q", tt.input, tt.expectedName, fl.Name)
+		}
+	}
+}
+
 func TestFunctionLiteralParsing(t *testing.T) {
 	input := "fn(x,y) { x + y; }"
 
@@ -883,7 +1001,7 @@ func TestHashingLiteral(t *testing.T) {
 			t.Fatalf("hash.Pairs element key not StringLiteral, got: %T (%+v)", key, key)
 		}
 
-		expectedValue := expected[literal.String()]
+		expectedValue := expected[literal.Value]
 
 		testIntegerLiteral(t, value, expectedValue)
 	}
@@ -946,7 +1064,7 @@ func TestParsingLiteralWithTypes(t *testing.T) {
 			continue
 		}
 
-		testFunc, ok := tests[literal.String()]
+		testFunc, ok := tests[literal.Value]
 		if !ok {
 			t.Errorf("No test function for key: %s", key.String())
 			continue
@@ -955,3 +1073,346 @@ func TestParsingLiteralWithTypes(t *testing.T) {
 		testFunc(val)
 	}
 }
+
+func TestNodePositions(t *testing.T) {
+	input := "let add = fn(a, b) { a + b };\nadd(1, [2, 3])[0];\n{\"k\": -1}"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserError(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements, got %d", len(program.Statements))
+	}
+
+	let := program.Statements[0].(*ast.LetStatement)
+	fn := let.Value.(*ast.FunctionLiteral)
+	body := fn.Body.Statements[0].(*ast.ExpressionStatement)
+	index := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.IndexExpression)
+	call := index.Left.(*ast.CallExpression)
+	hash := program.Statements[2].(*ast.ExpressionStatement).Expression.(*ast.HashLiteral)
+
+	tests := []struct {
+		node          ast.Node
+		expectedStart string
+		expectedEnd   string
+	}{
+		{program, "1:1", "3:10"},
+		{let, "1:1", "1:29"},
+		{let.Name, "1:5", "1:8"},
+		{fn, "1:11", "1:29"},
+		{fn.Body, "1:20", "1:29"},
+		{body.Expression, "1:22", "1:27"},
+		{index, "2:1", "2:18"},
+		{call, "2:1", "2:15"},
+		{call.Arguments[1], "2:8", "2:14"},
+		{hash, "3:1", "3:10"},
+	}
+
+	for _, tt := range tests {
+		if tt.node.Pos().String() != tt.expectedStart {
+			t.Errorf("%T Pos() wrong. Expected: %s, got: %s", tt.node, tt.expectedStart, tt.node.Pos())
+		}
+		if tt.node.End().String() != tt.expectedEnd {
+			t.Errorf("%T End() wrong. Expected: %s, got: %s", tt.node, tt.expectedEnd, tt.node.End())
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	input := "let x = 1;\nlet y 2;"
+
+	l := lexer.NewFile("main.mk", input)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected parser errors, got none")
+	}
+
+	expected := "main.mk:2:7: Expect token to be =, got INT instead"
+	if errors[0] != expected {
+		t.Errorf("wrong error message. Expected: %q, got: %q", expected, errors[0])
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedKind     ErrorKind
+		expectedPos      string
+		expectedExpected []token.TokenType
+		expectedActual   token.TokenType
+	}{
+		{"let 5 = x;", UnexpectedToken, "1:5", []token.TokenType{token.IDENT}, token.INT},
+		{"add(1, 2", UnexpectedToken, "1:9", []token.TokenType{token.RPAREN}, token.EOF},
+		{"let x = ;", NoPrefixParseFn, "1:9", nil, token.SEMICOLON},
+		{"99999999999999999999", InvalidLiteral, "1:1", nil, token.INT},
+		{"let x = 1 @ 2;", LexicalError, "1:11", nil, token.ILLEGAL},
+		{"let mask = 0b102;", LexicalError, "1:16", nil, token.INT},
+		{"let s = \"\xff\";", LexicalError, "1:10", nil, token.STRING},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.ParseErrors()
+		if len(errors) == 0 {
+			t.Errorf("input %q: expected parse errors, got none", tt.input)
+			continue
+		}
+
+		err := errors[0]
+		if err.Kind != tt.expectedKind {
+			t.Errorf("input %q: wrong kind. Expected: %s, got: %s", tt.input, tt.expectedKind, err.Kind)
+		}
+		if err.Pos.String() != tt.expectedPos {
+			t.Errorf("input %q: wrong position. Expected: %s, got: %s", tt.input, tt.expectedPos, err.Pos)
+		}
+		if fmt.Sprint(err.Expected) != fmt.Sprint(tt.expectedExpected) {
+			t.Errorf("input %q: wrong expected tokens. Expected: %v, got: %v", tt.input, tt.expectedExpected, err.Expected)
+		}
+		if err.Actual.Type != tt.expectedActual {
+			t.Errorf("input %q: wrong actual token. Expected: %s, got: %s", tt.input, tt.expectedActual, err.Actual.Type)
+		}
+		if p.Errors()[0] != err.Error() {
+			t.Errorf("input %q: Errors() not matching ParseErrors(). got %q and %q", tt.input, p.Errors()[0], err.Error())
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `
+let x 5;
+let y = (1 + ;
+if (x { 1 } else { 2 };
+let f = fn(a) { let = 1; a };
+let z = 10;
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	expectedErrors := []string{
+		"2:7: Expect token to be =, got INT instead",
+		"3:14: no prefix parse function for ; found",
+		"4:7: Expect token to be ), got { instead",
+		"5:21: Expect token to be ident, got = instead",
+	}
+
+	errors := p.Errors()
+	if len(errors) != len(expectedErrors) {
+		t.Fatalf("wrong number of errors. Expected: %d, got: %d (%q)", len(expectedErrors), len(errors), errors)
+	}
+	for i, expected := range expectedErrors {
+		if errors[i] != expected {
+			t.Errorf("errors[%d] wrong. Expected: %q, got: %q", i, expected, errors[i])
+		}
+	}
+
+	if len(program.Statements) != 5 {
+		t.Fatalf("program.Statements does not contain 5 statements, got %d", len(program.Statements))
+	}
+
+	if _, ok := program.Statements[0].(*ast.BadStatement); !ok {
+		t.Errorf("program.Statements[0] is not BadStatement, got %T", program.Statements[0])
+	}
+
+	let, ok := program.Statements[1].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[1] is not LetStatement, got %T", program.Statements[1])
+	}
+	if _, ok := let.Value.(*ast.BadExpression); !ok {
+		t.Errorf("let.Value is not BadExpression, got %T", let.Value)
+	}
+
+	stm, ok := program.Statements[2].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[2] is not ExpressionStatement, got %T", program.Statements[2])
+	}
+	if _, ok := stm.Expression.(*ast.BadExpression); !ok {
+		t.Errorf("stm.Expression is not BadExpression, got %T", stm.Expression)
+	}
+
+	fn := program.Statements[3].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if _, ok := fn.Body.Statements[0].(*ast.BadStatement); !ok {
+		t.Errorf("fn.Body.Statements[0] is not BadStatement, got %T", fn.Body.Statements[0])
+	}
+	if len(fn.Body.Statements) != 2 {
+		t.Errorf("fn.Body.Statements does not contain 2 statements, got %d", len(fn.Body.Statements))
+	}
+
+	if !testLetStatement(t, program.Statements[4], "z") {
+		return
+	}
+}
+
+func TestUnclosedListsAreBadExpressions(t *testing.T) {
+	inputs := []string{
+		"put(1, 2",
+		"[1, 2",
+		"fn(a b) {}",
+	}
+
+	for _, input := range inputs {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("expected an error for %q", input)
+			continue
+		}
+
+		stm, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			t.Fatalf("program.Statements[0] is not ExpressionStatement, got %T", program.Statements[0])
+		}
+		if _, ok := stm.Expression.(*ast.BadExpression); !ok {
+			t.Errorf("%q is not BadExpression, got %T", input, stm.Expression)
+		}
+	}
+}
+
+func TestStringLiteralRoundTrip(t *testing.T) {
+	inputs := []string{
+		`"tab\there \"quoted\" \\ \u{7}"`,
+		"`raw\nstring \\n`",
+	}
+
+	for _, input := range inputs {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		checkParserError(t, p)
+
+		printed := program.String()
+		reparsed := New(lexer.New(printed))
+		reprogram := reparsed.ParseProgram()
+		checkParserError(t, reparsed)
+
+		original := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.StringLiteral)
+		again := reprogram.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.StringLiteral)
+		if original.Value != again.Value {
+			t.Errorf("value changed after printing %q. Expected: %q, got: %q", printed, original.Value, again.Value)
+		}
+	}
+}
+
+func TestDocComments(t *testing.T) {
+	input := `/// Adds two numbers.
+///
+/// Works on strings too.
+let add = fn(a, b) { a + b };
+
+/// Not attached, there is a blank line.
+
+let one = 1;
+// Plain comments are not doc comments.
+let two = 2;
+let three = 3; /// trailing
+let four = 4;
+let f = fn() {
+  /// Nested lets get docs too.
+  let inner = 1;
+  inner
+};`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserError(t, p)
+
+	tests := []struct {
+		name        string
+		expectedDoc string
+	}{
+		{"add", "Adds two numbers.\n\nWorks on strings too."},
+		{"one", ""},
+		{"two", ""},
+		{"three", ""},
+		{"four", ""},
+		{"f", ""},
+	}
+
+	if len(program.Statements) != len(tests) {
+		t.Fatalf("program.Statements does not contain %d statements, got %d", len(tests), len(program.Statements))
+	}
+
+	for i, tt := range tests {
+		let := program.Statements[i].(*ast.LetStatement)
+		if let.Name.Value != tt.name {
+			t.Fatalf("statement %d is not `%s`, got `%s`", i, tt.name, let.Name.Value)
+		}
+		if let.Doc.Text() != tt.expectedDoc {
+			t.Errorf("wrong doc for %s. Expected: %q, got: %q", tt.name, tt.expectedDoc, let.Doc.Text())
+		}
+	}
+
+	fn := program.Statements[5].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	inner := fn.Body.Statements[0].(*ast.LetStatement)
+	if inner.Doc.Text() != "Nested lets get docs too." {
+		t.Errorf("wrong doc for inner. got: %q", inner.Doc.Text())
+	}
+}
+
+func TestIntegerLiteralBases(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0xFF", 255},
+		{"0o755", 493},
+		{"0b1010", 10},
+		{"1_000_000", 1000000},
+		{"0x_dead_BEEF", 0xdeadbeef},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserError(t, p)
+
+		stm := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stm.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("stm.Expression not IntegerLiteral, got %T", stm.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %d, got %d", tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E3", 2500},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserError(t, p)
+
+		stm := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stm.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("stm.Expression not FloatLiteral, got %T", stm.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g, got %g", tt.expected, literal.Value)
+		}
+	}
+
+	p := New(lexer.New("1e400"))
+	p.ParseProgram()
+	if len(p.ParseErrors()) != 1 || p.ParseErrors()[0].Kind != InvalidLiteral {
+		t.Errorf("expected an InvalidLiteral error for 1e400, got %q", p.Errors())
+	}
+}
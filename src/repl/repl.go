@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 
+	"monkey/src/diagnostics"
 	"monkey/src/evaluator"
 	"monkey/src/lexer"
 	"monkey/src/object"
@@ -17,11 +18,19 @@ import (
 
 const PROMPT = ">>> "
 
+// Number of input lines kept to show the source of errors, a function
+// defined further back has its errors shown without the line
+const maxSources = 1000
+
 func Start(in io.Reader, out io.Writer) {
 	scanner := bufio.NewScanner(in)
 	env := object.NewEnvironment()
 
-	for {
+	// Every input line is its own file, so an error raised by a function
+	// defined on an earlier line is shown against the line it comes from
+	sources := diagnostics.NewSources(maxSources)
+
+	for n := 1; ; n++ {
 		fmt.Fprint(out, PROMPT)
 		scanned := scanner.Scan()
 
@@ -29,16 +38,23 @@ func Start(in io.Reader, out io.Writer) {
 			return
 		}
 		line := scanner.Text()
-		l := lexer.New(line)
+		filename := fmt.Sprintf("<stdin-%d>", n)
+
+		l := lexer.NewFile(filename, line)
 		p := parser.New(l)
 		program := p.ParseProgram()
 
 		if len(p.Errors()) != 0 {
-			printParserError(out, p.Errors())
+			printParserError(out, line, p.ParseErrors())
 			continue
 		}
 
-		evaluated := evaluator.Eval(program, env)
+		sources.Add(filename, line)
+		evaluated := evaluator.SafeEval(program, env)
+		if err, ok := evaluated.(*object.Error); ok {
+			diagnostics.Render(out, sources.Get(err.Pos.Filename), diagnostics.FromError(err), diagnostics.Options{})
+			continue
+		}
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -46,8 +62,8 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func printParserError(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+func printParserError(out io.Writer, src string, errors []*parser.ParseError) {
+	for _, err := range errors {
+		diagnostics.Render(out, src, diagnostics.FromParseError(err), diagnostics.Options{})
 	}
 }
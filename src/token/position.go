@@ -0,0 +1,45 @@
+package token
+
+import "fmt"
+
+// A location in the source code. Line and Column start at 1, Offset is the
+// byte offset from the start of the input and starts at 0.
+// Column is counted in bytes, the same way the go toolchain does it.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// A position is valid when it has a line number, the zero value is not
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// Advance returns the position n bytes further on the same line
+func (p Position) Advance(n int) Position {
+	p.Offset += n
+	p.Column += n
+	return p
+}
+
+// String returns the position in the form of:
+//
+//	file:line:column
+//	line:column      (no file name)
+//	file             (invalid position with a file name)
+//	-                (invalid position without a file name)
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
@@ -20,10 +20,13 @@ package token
 // Let's just take our token's type as a string for now
 type TokenType string
 
-// Each token will have a type, and their respective literal
+// Each token will have a type, their respective literal and where in the
+// source it starts (Pos) and ends (End, the position right after the token)
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
+	End     Position
 }
 
 var keywords = map[string]TokenType{
@@ -51,6 +54,7 @@ const (
 	// Identifier
 	IDENT = "ident"
 	INT   = "INT"
+	FLOAT = "FLOAT"
 
 	// Operators
 	ASSIGN   = "="
@@ -61,6 +65,22 @@ const (
 	SLASH    = "/"
 	NOT_EQ   = "!="
 	EQ       = "=="
+	LT_EQ    = "<="
+	GT_EQ    = ">="
+	PERCENT  = "%"
+	POWER    = "**"
+
+	// Logical operators
+	AND = "&&"
+	OR  = "||"
+
+	// Bitwise operators
+	AMPERSAND   = "&"
+	PIPE        = "|"
+	CARET       = "^"
+	TILDE       = "~"
+	SHIFT_LEFT  = "<<"
+	SHIFT_RIGHT = ">>"
 
 	// Delimiter
 	COMMA     = ","
@@ -86,4 +106,7 @@ const (
 	ELSE     = "ELSE"
 
 	STRING = "STRING"
+
+	// Never returned by NextToken, the lexer keeps comments apart
+	COMMENT = "COMMENT"
 )